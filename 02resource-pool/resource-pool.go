@@ -1,18 +1,35 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/google/uuid"
 
 	"bufio"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
 )
 
 type Device struct {
@@ -22,27 +39,148 @@ type Device struct {
 }
 
 var (
+	// poolLock guards the lookup table and the fencing token, so that a request is checked and applied at once
+	poolLock          sync.Mutex
 	deviceLookUpTable []Device
 	devIDToUUIDMap    = make(map[string]string)
+
+	// The highest fencing token seen, requests carrying a lower token come from a deposed leader
+	fencingToken int64 = -1
+
+	// Rejects the mutating requests without a fencing token, set when reconfig-mgr runs with leader election
+	requireFencingToken = flag.Bool("require-fencing-token", false, "reject the attach and detach requests without an "+fencingTokenHeader+" header")
+)
+
+const fencingTokenHeader = "X-Fencing-Token"
+
+var (
+	poolDevices = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubecomp_pool_devices",
+		Help: "Devices of the pool by host port, the host port is empty for the unattached devices.",
+	}, []string{"hostport"})
+	apiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubecomp_pool_http_requests_total",
+		Help: "Requests to the resource pool API by handler, method and status code.",
+	}, []string{"handler", "method", "code"})
+	fencingRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kubecomp_pool_fencing_rejections_total",
+		Help: "Requests rejected because of a stale fencing token.",
+	})
 )
 
+// Exports the spans to the OTLP collector given by the OTEL_EXPORTER_OTLP_ENDPOINT environment variable,
+// e.g. http://otel-collector.kubecomp:4317. Without it, the spans are dropped but the trace IDs still
+// become the request IDs of the logs.
+func setupTracing() (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("resource-pool"))),
+	}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		exporter, err := otlptracegrpc.New(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Sets the number of devices attached to every host port, poolLock must be held
+func updateDeviceMetrics() {
+	poolDevices.Reset()
+	for _, dev := range deviceLookUpTable {
+		poolDevices.WithLabelValues(dev.HostPort).Inc()
+	}
+}
+
+// Returns the logger of the request, which carries the ID of the reconfiguration, the trace ID started by the scheduler
+func requestLogger(r *http.Request) klog.Logger {
+	return klog.LoggerWithValues(klog.Background(), "requestID", trace.SpanContextFromContext(r.Context()).TraceID().String())
+}
+
+// Handles the PUT /debug/flags/v request, which changes the log verbosity at runtime
+func putLogLevel(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level := strings.TrimSpace(string(body))
+	if err := flag.Set("v", level); err != nil {
+		http.Error(w, fmt.Sprintf("invalid log level %q: %v", level, err), http.StatusBadRequest)
+		return
+	}
+	klog.InfoS("Log level changed", "level", level)
+	fmt.Fprintf(w, "successfully set klog.logging.verbosity to %s\n", level)
+}
+
+// Counts the requests served by the handler, and traces them as children of the span of the caller
+func instrument(name string, handler http.HandlerFunc) http.Handler {
+	counted := promhttp.InstrumentHandlerCounter(apiRequests.MustCurryWith(prometheus.Labels{"handler": name}), handler)
+	return otelhttp.NewHandler(counted, name, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method + " /" + name
+	}))
+}
+
+// Rejects the request if its fencing token is lower than the highest one seen, poolLock must be held until the
+// request is applied. Requests without a token are accepted unless -require-fencing-token is set.
+func checkFencingToken(w http.ResponseWriter, r *http.Request) bool {
+	value := r.Header.Get(fencingTokenHeader)
+	if value == "" {
+		if *requireFencingToken {
+			http.Error(w, "Fencing token is required", http.StatusPreconditionRequired)
+			requestLogger(r).Info("Rejected a request without a fencing token")
+			fencingRejections.Inc()
+			return false
+		}
+		return true
+	}
+	token, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid fencing token", http.StatusBadRequest)
+		return false
+	}
+
+	if token < fencingToken {
+		http.Error(w, fmt.Sprintf("Fencing token %d is stale, the current one is %d", token, fencingToken), http.StatusConflict)
+		requestLogger(r).Info("Rejected a request with a stale fencing token", "fencingToken", token, "current", fencingToken)
+		fencingRejections.Inc()
+		return false
+	}
+	fencingToken = token
+	return true
+}
+
 // Handles the GET /resources request
 func getResources(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	poolLock.Lock()
+	defer poolLock.Unlock()
 	if err := json.NewEncoder(w).Encode(deviceLookUpTable); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		log.Println("Error encoding response:", err)
+		requestLogger(r).Error(err, "Error encoding response")
 	}
 }
 
 // Handles the POST /allocation request
 func attachResource(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-
 	var device Device
 	if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		log.Println("Error decoding request body:", err)
+		requestLogger(r).Error(err, "Error decoding request body")
+		return
+	}
+
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("devid", device.DevID), attribute.String("hostport", device.HostPort))
+
+	poolLock.Lock()
+	defer poolLock.Unlock()
+	if !checkFencingToken(w, r) {
 		return
 	}
 
@@ -58,6 +196,8 @@ func attachResource(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			deviceLookUpTable[index].HostPort = device.HostPort // Attach resource (set HostPort)
+			updateDeviceMetrics()
+			requestLogger(r).Info("Attached device", "devid", device.DevID, "hostport", device.HostPort)
 			found = true
 			break
 		}
@@ -74,18 +214,27 @@ func attachResource(w http.ResponseWriter, r *http.Request) {
 // Handles the DELETE /allocation request
 func detachResource(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-
 	var device Device
 	if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		log.Println("Error decoding request body:", err)
+		requestLogger(r).Error(err, "Error decoding request body")
+		return
+	}
+
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("devid", device.DevID))
+
+	poolLock.Lock()
+	defer poolLock.Unlock()
+	if !checkFencingToken(w, r) {
 		return
 	}
 
 	found := false
 	for index, dev := range deviceLookUpTable {
 		if dev.DevID == device.DevID {
+			requestLogger(r).Info("Detached device", "devid", device.DevID, "hostport", dev.HostPort)
 			deviceLookUpTable[index].HostPort = "" // Detach resource
+			updateDeviceMetrics()
 			found = true
 			break
 		}
@@ -136,22 +285,37 @@ func parseResourceConfig(path string) error {
 // Initializes the server and routes
 func startServer() {
 	r := mux.NewRouter()
-	r.HandleFunc("/resources", getResources).Methods("GET")
-	r.HandleFunc("/allocation", attachResource).Methods("POST")
-	r.HandleFunc("/allocation", detachResource).Methods("DELETE")
-	log.Fatal(http.ListenAndServe(":8000", r))
+	r.Handle("/resources", instrument("resources", getResources)).Methods("GET")
+	r.Handle("/allocation", instrument("allocation", attachResource)).Methods("POST")
+	r.Handle("/allocation", instrument("allocation", detachResource)).Methods("DELETE")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	r.HandleFunc("/debug/flags/v", putLogLevel).Methods("PUT")
+	klog.ErrorS(http.ListenAndServe(":8000", r), "Failed to serve")
+	klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatal("Usage: ./resource-pool <resource-config-path>")
-		return
+	klog.InitFlags(nil)
+	flag.Parse()
+	defer klog.Flush()
+	if flag.NArg() < 1 {
+		klog.ErrorS(nil, "Usage: ./resource-pool [-v=<level>] [-require-fencing-token] <resource-config-path>")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 
-	path := os.Args[1]
+	path := flag.Arg(0)
 	if err := parseResourceConfig(path); err != nil {
-		log.Fatalf("Error parsing resource configuration file: %v", err)
+		klog.ErrorS(err, "Error parsing resource configuration file", "path", path)
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	updateDeviceMetrics()
+
+	shutdownTracing, err := setupTracing()
+	if err != nil {
+		klog.ErrorS(err, "Error setting up tracing")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
+	defer shutdownTracing(context.Background())
 
 	startServer()
 }
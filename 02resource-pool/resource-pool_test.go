@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckFencingToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		require  bool
+		current  int64
+		token    string // empty sends no header
+		want     bool
+		wantCode int
+		wantNext int64
+	}{
+		{name: "no token is accepted by default", current: 3, want: true, wantNext: 3},
+		{name: "no token is rejected with -require-fencing-token", require: true, current: 3, want: false, wantCode: http.StatusPreconditionRequired, wantNext: 3},
+		{name: "newer token is accepted and kept", current: 3, token: "4", want: true, wantNext: 4},
+		{name: "current token is accepted", require: true, current: 3, token: "3", want: true, wantNext: 3},
+		{name: "stale token is rejected", current: 3, token: "2", want: false, wantCode: http.StatusConflict, wantNext: 3},
+		{name: "invalid token is rejected", current: 3, token: "x", want: false, wantCode: http.StatusBadRequest, wantNext: 3},
+	}
+
+	defer func(require bool, current int64) {
+		*requireFencingToken = require
+		fencingToken = current
+	}(*requireFencingToken, fencingToken)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*requireFencingToken = tt.require
+			fencingToken = tt.current
+			r := httptest.NewRequest(http.MethodPost, "/allocation", nil)
+			if tt.token != "" {
+				r.Header.Set(fencingTokenHeader, tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			if got := checkFencingToken(w, r); got != tt.want {
+				t.Errorf("checkFencingToken() = %v, want %v", got, tt.want)
+			}
+			if !tt.want && w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if fencingToken != tt.wantNext {
+				t.Errorf("fencing token = %d, want %d", fencingToken, tt.wantNext)
+			}
+		})
+	}
+}
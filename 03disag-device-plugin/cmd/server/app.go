@@ -2,48 +2,67 @@ package main
 
 import (
 	"my-device-plugin/pkg/server"
+	"os"
 	"path/filepath"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	"gopkg.in/fsnotify.v1"
+	"k8s.io/klog/v2"
 )
 
 func main() {
-	log.Info("Disaggregated device plugin starts.")
+	server.SetupLogging()
+	defer klog.Flush()
+	klog.InfoS("Disaggregated device plugin starts", "node", os.Getenv("NODE_NAME"))
 	diagDevSrv := server.NewDisagDevServer()
+	// The reserved devices are known before the first list
+	if err := diagDevSrv.WatchReserved(); err != nil {
+		klog.ErrorS(err, "Failed to watch the devices reserved by the DRA driver, they may be advertised")
+	}
 	go diagDevSrv.Run()
+	go server.ServeMetrics()
 
 	// Registers with Kubelet
-	if err := diagDevSrv.RegisterToKubelet(); err != nil {
-		log.Fatalf("Failed to register with Kubelet: %v", err)
+	if err := diagDevSrv.RegisterWhenCabled(); err != nil {
+		fatal(err, "Failed to register with Kubelet")
+	}
+	if err := diagDevSrv.LabelNode(); err != nil {
+		klog.ErrorS(err, "Failed to label the node, reconfig-mgr and the scheduler rely on the topology only")
+	}
+	if err := diagDevSrv.WatchTopology(); err != nil {
+		klog.ErrorS(err, "Failed to watch the topology, changes require a restart")
 	}
-	log.Info("Successfully registered with Kubelet.")
 
 	// Listens to kubelet.sock
 	devicePluginSocket := filepath.Join(server.DevicePluginPath, server.KubeletSocket)
-	log.Infof("Device plugin socket: %s", devicePluginSocket)
+	klog.InfoS("Device plugin socket", "path", devicePluginSocket)
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		log.Fatalf("Failed to create FS watcher: %v", err)
+		fatal(err, "Failed to create FS watcher")
 	}
 	defer watcher.Close()
 
 	if err := watcher.Add(server.DevicePluginPath); err != nil {
-		log.Fatalf("Failed to watch path %s: %v", server.DevicePluginPath, err)
+		fatal(err, "Failed to watch path", "path", server.DevicePluginPath)
 	}
-	log.Info("Watching for changes on kubelet.sock")
+	klog.InfoS("Watching for changes on kubelet.sock")
 
 	for {
 		select {
 		case event := <-watcher.Events:
 			if event.Name == devicePluginSocket && event.Op&fsnotify.Create == fsnotify.Create {
 				time.Sleep(time.Second)
-				log.Fatalf("inotify: %s created, restarting.", devicePluginSocket)
+				fatal(nil, "Kubelet socket created, restarting", "path", devicePluginSocket)
 			}
 		case err := <-watcher.Errors:
-			log.Fatalf("inotify: %s", err)
+			fatal(err, "inotify failed")
 		}
 	}
 }
+
+// Logs the error and exits
+func fatal(err error, msg string, keysAndValues ...interface{}) {
+	klog.ErrorS(err, msg, keysAndValues...)
+	klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+}
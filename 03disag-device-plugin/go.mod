@@ -26,12 +26,17 @@ replace (
 )
 
 require (
-	github.com/fsnotify/fsnotify v1.6.0 // indirect
-	github.com/sirupsen/logrus v1.8.1
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/prometheus/client_golang v1.14.0
 	google.golang.org/grpc v1.49.0
 	gopkg.in/fsnotify.v1 v1.4.7
 	gopkg.in/yaml.v3 v3.0.1
+	k8s.io/api v0.26.1
+	k8s.io/apimachinery v0.26.1
+	k8s.io/client-go v0.26.1
+	k8s.io/klog/v2 v2.80.1
 	k8s.io/kubelet v0.26.1
+	kubecomp/topology v0.0.0
 )
 
 replace k8s.io/component-helpers => k8s.io/component-helpers v0.26.1
@@ -51,3 +56,5 @@ replace k8s.io/pod-security-admission => k8s.io/pod-security-admission v0.26.1
 replace k8s.io/sample-cli-plugin => k8s.io/sample-cli-plugin v0.26.1
 
 replace k8s.io/sample-controller => k8s.io/sample-controller v0.26.1
+
+replace kubecomp/topology => ../topology
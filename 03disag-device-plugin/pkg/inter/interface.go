@@ -2,19 +2,25 @@ package inter
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"strings"
+	"sync"
 
-	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+
+	"kubecomp/topology"
 )
 
 type FalconInterface struct {
+	lock     sync.RWMutex
+	topo     *topology.Topology
 	endpoint string
-	hostPort string
+	hostPort string // empty while the node is cabled to no host port
+	pool     string
 }
 
 type DevicePair struct {
@@ -22,49 +28,114 @@ type DevicePair struct {
 	GpuUUID string
 }
 
+// NewDevInterface loads the topology, an invalid one is replaced by the default until the file is fixed
 func NewDevInterface() *FalconInterface {
-	// Read device-plugin-config.yaml
-	var devicePluginConfigPath string = "/etc/kubernetes/device-plugin-config.yaml"
-	buf, err := os.ReadFile(devicePluginConfigPath)
+	topo, err := LoadTopology()
 	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
+		klog.ErrorS(err, "Invalid topology, using the default endpoint and the host port file until it is fixed")
+		topo = topology.Default()
 	}
+	fi := &FalconInterface{}
+	fi.SetTopology(topo)
+	return fi
+}
 
-	var config map[string]string
-	if err := yaml.Unmarshal(buf, &config); err != nil {
-		log.Fatalf("Failed to unmarshal YAML: %v", err)
+// LoadTopology reads the topology file, the legacy keys of device-plugin-config.yaml are still read without it
+func LoadTopology() (*topology.Topology, error) {
+	topo, err := topology.Load(topology.DefaultPath)
+	if errors.Is(err, os.ErrNotExist) {
+		klog.InfoS("Topology file not found, reading the deprecated local_ips and host_ports keys", "path", topology.DefaultPath)
+		return legacyTopology()
 	}
+	return topo, err
+}
 
-	// Extracts configuration values defined in charts/values.yaml
-	ipList := strings.Split(config["local_ips"], ",")
-	hostPortList := strings.Split(config["host_ports"], ",")
-	endpoint := config["api_endpoint"]
-	nodeIP := os.Getenv("NODE_IP")
+// SetTopology applies a topology loaded at startup or reloaded, and returns whether the host port of the node changed
+func (fi *FalconInterface) SetTopology(topo *topology.Topology) bool {
+	fi.lock.Lock()
+	fi.topo = topo
+	fi.endpoint = topo.Endpoints.Resources
+	fi.lock.Unlock()
+	return fi.DetectHostPort()
+}
 
-	var hostPort string
-	for i, ip := range ipList {
-		if ip == nodeIP {
-			hostPort = hostPortList[i]
-			break
+// DetectHostPort finds the host port of the node and returns whether it changed.
+// The host port file on the node comes first, then the node is looked up in the topology by name, then by IP.
+// A node cabled to no host port advertises no device until one is found.
+func (fi *FalconInterface) DetectHostPort() bool {
+	nodeName := os.Getenv("NODE_NAME")
+	nodeIP := os.Getenv("NODE_IP")
+	fi.lock.RLock()
+	topo := fi.topo
+	fi.lock.RUnlock()
+
+	var port, pool, source string
+	local, err := topology.ReadHostPortFile(topology.DefaultHostPortPath)
+	if err == nil {
+		port, pool, source = local.Port, local.Pool, topology.DefaultHostPortPath
+	} else if !errors.Is(err, os.ErrNotExist) {
+		klog.ErrorS(err, "Ignoring the host port file")
+	}
+	if hp, ok := topo.FindNode(nodeName, nodeIP); ok {
+		if port == "" {
+			port, pool, source = hp.Port, hp.Pool, "topology"
+		} else if hp.Port != port {
+			klog.InfoS("The host port file overrides the topology", "node", nodeName, "hostport", port, "topologyHostport", hp.Port)
 		}
 	}
-
-	if hostPort == "" || endpoint == "" {
-		log.Fatalf("Host port or endpoint is missing")
+	if port == "" {
+		klog.InfoS("The node is cabled to no host port, no device is advertised", "node", nodeName, "nodeIP", nodeIP)
+	} else {
+		klog.InfoS("Found the host port of the node", "node", nodeName, "nodeIP", nodeIP, "hostport", port, "pool", pool, "source", source)
 	}
 
-	log.Infof("Node IP: %s", nodeIP)
-	log.Infof("Host Port: %s", hostPort)
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	changed := fi.hostPort != port || fi.pool != pool
+	fi.hostPort = port
+	fi.pool = pool
+	return changed
+}
+
+// Builds the topology from the comma-separated lists of device-plugin-config.yaml
+func legacyTopology() (*topology.Topology, error) {
+	var devicePluginConfigPath string = "/etc/kubernetes/device-plugin-config.yaml"
+	buf, err := os.ReadFile(devicePluginConfigPath)
+	if err != nil {
+		return nil, err
+	}
 
-	return &FalconInterface{
-		endpoint: endpoint,
-		hostPort: hostPort,
+	var config map[string]string
+	if err := yaml.Unmarshal(buf, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", devicePluginConfigPath, err)
 	}
+	return topology.FromLegacy(config["api_endpoint"], "", "", config["local_ips"], config["host_ports"], "")
+}
+
+// HostPort returns the host port of the pool the node is connected to
+func (fi *FalconInterface) HostPort() string {
+	fi.lock.RLock()
+	defer fi.lock.RUnlock()
+	return fi.hostPort
+}
+
+// Pool returns the pool the node is connected to, empty while the node is cabled to no host port
+func (fi *FalconInterface) Pool() string {
+	fi.lock.RLock()
+	defer fi.lock.RUnlock()
+	return fi.pool
 }
 
 // Retrieves the list of devices from the resource pool API.
 func (fi *FalconInterface) GetResource() ([]DevicePair, error) {
-	req, err := http.NewRequest(http.MethodGet, fi.endpoint, nil)
+	fi.lock.RLock()
+	endpoint, hostPort := fi.endpoint, fi.hostPort
+	fi.lock.RUnlock()
+	if hostPort == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating HTTP request: %v", err)
 	}
@@ -90,7 +161,7 @@ func (fi *FalconInterface) GetResource() ([]DevicePair, error) {
 	// Returns the devices connected to the host
 	var devices []DevicePair
 	for _, res := range result {
-		if res["hostport"] == fi.hostPort {
+		if res["hostport"] == hostPort {
 			devices = append(devices, DevicePair{
 				DevID:   res["devid"],
 				GpuUUID: res["uuid"],
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+
+	"kubecomp/topology"
+)
+
+// LabelNode advertises the host port and the pool of the node through its labels, from which reconfig-mgr and the
+// scheduler derive the topology. The labels are removed while the node is cabled to no host port.
+func (s *DisagDevServer) LabelNode() error {
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		return fmt.Errorf("NODE_NAME is not set")
+	}
+	clientset, err := s.kubeClient()
+	if err != nil {
+		return err
+	}
+
+	// A null value removes the label in a merge patch
+	labels := map[string]interface{}{topology.HostPortLabel: nil, topology.PoolLabel: nil}
+	if port := s.devIF.HostPort(); port != "" {
+		labels[topology.HostPortLabel] = port
+		labels[topology.PoolLabel] = s.devIF.Pool()
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"labels": labels}})
+	if err != nil {
+		return err
+	}
+	if _, err := clientset.CoreV1().Nodes().Patch(context.Background(), nodeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	klog.InfoS("Labeled the node", "node", nodeName, "hostport", s.devIF.HostPort(), "pool", s.devIF.Pool())
+	return nil
+}
+
+// Returns the in-cluster clientset, created on first use
+func (s *DisagDevServer) kubeClient() (kubernetes.Interface, error) {
+	s.clientLock.Lock()
+	defer s.clientLock.Unlock()
+	if s.clientset == nil {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, err
+		}
+		s.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.clientset, nil
+}
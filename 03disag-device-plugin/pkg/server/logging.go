@@ -0,0 +1,54 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// SetupLogging registers the klog flags and sets the verbosity from the log_level key of the config file
+func SetupLogging() {
+	klog.InitFlags(nil)
+	buf, err := os.ReadFile(DevicePluginConfigPath)
+	if err != nil {
+		return
+	}
+	var config map[string]string
+	if err := yaml.Unmarshal(buf, &config); err != nil || config["log_level"] == "" {
+		return
+	}
+	if err := flag.Set("v", config["log_level"]); err != nil {
+		klog.ErrorS(err, "Invalid log level", "level", config["log_level"])
+	}
+}
+
+// Handles the PUT /debug/flags/v request, which changes the verbosity at runtime
+func putLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level := strings.TrimSpace(string(body))
+	if err := flag.Set("v", level); err != nil {
+		http.Error(w, fmt.Sprintf("invalid log level %q: %v", level, err), http.StatusBadRequest)
+		return
+	}
+	klog.InfoS("Log level changed", "level", level)
+	fmt.Fprintf(w, "successfully set klog.logging.verbosity to %s\n", level)
+}
+
+// Returns the logger carrying the node of the device plugin
+func nodeLogger() klog.Logger {
+	return klog.LoggerWithValues(klog.Background(), "node", os.Getenv("NODE_NAME"))
+}
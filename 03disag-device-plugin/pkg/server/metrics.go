@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+const defaultMetricsAddress string = ":9100"
+
+var (
+	advertisedDevices = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kubecomp_device_plugin_devices",
+		Help: "Pool GPUs attached to the host port of this node and advertised to kubelet.",
+	})
+	allocations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kubecomp_device_plugin_allocations_total",
+		Help: "Containers which were allocated pool GPUs by kubelet.",
+	})
+	allocatedDevices = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kubecomp_device_plugin_allocated_devices_total",
+		Help: "Pool GPUs allocated to containers.",
+	})
+	poolAPIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubecomp_pool_api_requests_total",
+		Help: "Requests to the resource pool API by operation and result.",
+	}, []string{"operation", "result"})
+)
+
+// Returns the metrics_address of the config file, or the default address
+func metricsAddress() string {
+	buf, err := os.ReadFile(DevicePluginConfigPath)
+	if err != nil {
+		return defaultMetricsAddress
+	}
+	var config map[string]string
+	if err := yaml.Unmarshal(buf, &config); err != nil || config["metrics_address"] == "" {
+		return defaultMetricsAddress
+	}
+	return config["metrics_address"]
+}
+
+// ServeMetrics serves the Prometheus metrics at /metrics, and the log verbosity at /debug/flags/v
+func ServeMetrics() {
+	addr := metricsAddress()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/debug/flags/v", putLogLevel)
+	klog.InfoS("Serving metrics", "address", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		klog.ErrorS(err, "Failed to serve metrics", "address", addr)
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"os"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const defaultReservedConfigMap string = "pool-dra-reserved"
+
+// reservedDevices tracks the devices reserved by the DRA driver for its ResourceClaims,
+// published in a ConfigMap keyed by DevID in the namespace of the device plugin
+type reservedDevices struct {
+	sync.RWMutex
+	devices map[string]bool
+}
+
+func (r *reservedDevices) has(devID string) bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.devices[devID]
+}
+
+// Replaces the reserved devices and returns whether they changed
+func (r *reservedDevices) set(data map[string]string) bool {
+	devices := make(map[string]bool, len(data))
+	for dev := range data {
+		devices[dev] = true
+	}
+	r.Lock()
+	defer r.Unlock()
+	if len(devices) == len(r.devices) {
+		changed := false
+		for dev := range devices {
+			changed = changed || !r.devices[dev]
+		}
+		if !changed {
+			return false
+		}
+	}
+	r.devices = devices
+	return true
+}
+
+// Returns the dra_reserved_configmap of the config file, or the default name
+func reservedConfigMap() string {
+	buf, err := os.ReadFile(DevicePluginConfigPath)
+	if err != nil {
+		return defaultReservedConfigMap
+	}
+	var config map[string]string
+	if err := yaml.Unmarshal(buf, &config); err != nil || config["dra_reserved_configmap"] == "" {
+		return defaultReservedConfigMap
+	}
+	return config["dra_reserved_configmap"]
+}
+
+// WatchReserved stops advertising the devices the DRA driver reserves, and advertises them again once released
+func (s *DisagDevServer) WatchReserved() error {
+	clientset, err := s.kubeClient()
+	if err != nil {
+		return err
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	name := reservedConfigMap()
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+
+	update := func(data map[string]string) {
+		if !s.reserved.set(data) {
+			return
+		}
+		devIDs := make([]string, 0, len(data))
+		for dev := range data {
+			devIDs = append(devIDs, dev)
+		}
+		sort.Strings(devIDs)
+		nodeLogger().V(2).Info("Devices reserved by the DRA driver", "devids", devIDs)
+		s.refreshDevices()
+	}
+	_, err = factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if cm, ok := obj.(*v1.ConfigMap); ok {
+				update(cm.Data)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if cm, ok := obj.(*v1.ConfigMap); ok {
+				update(cm.Data)
+			}
+		},
+		DeleteFunc: func(interface{}) { update(nil) },
+	})
+	if err != nil {
+		return err
+	}
+	factory.Start(s.ctx.Done())
+	factory.WaitForCacheSync(s.ctx.Done())
+	klog.InfoS("Watching the devices reserved by the DRA driver", "configMap", klog.KRef(namespace, name))
+	return nil
+}
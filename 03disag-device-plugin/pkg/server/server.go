@@ -9,14 +9,17 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
 	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 
+	"kubecomp/topology"
 	"my-device-plugin/pkg/inter"
 )
 
@@ -37,6 +40,14 @@ type DisagDevServer struct {
 	gpuLookUp           map[string]string
 	deviceCheckInterval time.Duration
 	devIF               *inter.FalconInterface
+	refresh             chan struct{}    // lists the devices before the next check, when the host port or the reserved devices change
+	reserved            *reservedDevices // devices reserved by the DRA driver, never advertised
+
+	clientLock sync.Mutex
+	clientset  kubernetes.Interface // labels the node and watches the reserved devices, created on first use
+
+	regLock    sync.Mutex
+	registered bool
 }
 
 func NewDisagDevServer() *DisagDevServer {
@@ -49,12 +60,15 @@ func NewDisagDevServer() *DisagDevServer {
 		gpuLookUp:           make(map[string]string),
 		deviceCheckInterval: 1 * time.Second,
 		devIF:               inter.NewDevInterface(),
+		refresh:             make(chan struct{}, 1),
+		reserved:            &reservedDevices{devices: map[string]bool{}},
 	}
 }
 
 func (s *DisagDevServer) Run() error {
 	if err := s.listDevice(); err != nil {
-		log.Fatalf("Failed to list devices: %v", err)
+		nodeLogger().Error(err, "Failed to list devices", "hostport", s.devIF.HostPort())
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 
 	pluginapi.RegisterDevicePluginServer(s.srv, s)
@@ -71,16 +85,17 @@ func (s *DisagDevServer) Run() error {
 		lastCrashTime := time.Now()
 		restartCount := 0
 		for {
-			log.Printf("Start GPPC server for '%s'", resourceName)
+			klog.InfoS("Start GRPC server", "resource", resourceName)
 			err = s.srv.Serve(l)
 			if err == nil {
 				break
 			}
 
-			log.Printf("GRPC server for '%s' crashed with error: %v", resourceName, err)
+			klog.ErrorS(err, "GRPC server crashed", "resource", resourceName)
 
 			if restartCount > 5 {
-				log.Fatalf("GRPC server for '%s' has repeatedly crashed recently. Quitting", resourceName)
+				klog.ErrorS(nil, "GRPC server has repeatedly crashed recently. Quitting", "resource", resourceName)
+				klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 			}
 
 			if time.Since(lastCrashTime).Seconds() > 3600 {
@@ -117,7 +132,7 @@ func (s *DisagDevServer) RegisterToKubelet() error {
 		Endpoint:     path.Base(DevicePluginPath + falconSocket),
 		ResourceName: resourceName,
 	}
-	log.Infof("Register to kubelet with endpoint %s", req.Endpoint)
+	klog.InfoS("Register to kubelet", "endpoint", req.Endpoint)
 
 	if _, err := client.Register(context.Background(), req); err != nil {
 		return err
@@ -126,6 +141,66 @@ func (s *DisagDevServer) RegisterToKubelet() error {
 	return nil
 }
 
+// RegisterWhenCabled registers to kubelet once the node is cabled to a host port, an uncabled node does not
+// advertise the resource. It is called again whenever the topology changes.
+func (s *DisagDevServer) RegisterWhenCabled() error {
+	s.regLock.Lock()
+	defer s.regLock.Unlock()
+	if s.registered || s.devIF.HostPort() == "" {
+		return nil
+	}
+	if err := s.RegisterToKubelet(); err != nil {
+		return err
+	}
+	s.registered = true
+	klog.InfoS("Successfully registered with Kubelet", "hostport", s.devIF.HostPort())
+	return nil
+}
+
+// WatchTopology applies the changes of the topology file and of the host port file without restarting the device plugin.
+// When the host port of the node changes, the devices of the new port are sent to kubelet through ListAndWatch
+// and the labels of the node are updated.
+func (s *DisagDevServer) WatchTopology() error {
+	onError := func(err error) {
+		klog.ErrorS(err, "Ignoring the topology change")
+	}
+	err := topology.Watch(topology.DefaultPath, s.ctx.Done(), func(topo *topology.Topology) {
+		if s.devIF.SetTopology(topo) {
+			s.hostPortChanged()
+		}
+	}, onError)
+	if err != nil {
+		return err
+	}
+	// The directory of the host port file is optional
+	if err := topology.WatchFile(topology.DefaultHostPortPath, s.ctx.Done(), func([]byte) {
+		if s.devIF.DetectHostPort() {
+			s.hostPortChanged()
+		}
+	}, onError); err != nil {
+		klog.V(2).InfoS("Not watching the host port file", "path", topology.DefaultHostPortPath, "err", err)
+	}
+	return nil
+}
+
+func (s *DisagDevServer) hostPortChanged() {
+	s.refreshDevices()
+	if err := s.RegisterWhenCabled(); err != nil {
+		klog.ErrorS(err, "Failed to register with Kubelet")
+	}
+	if err := s.LabelNode(); err != nil {
+		klog.ErrorS(err, "Failed to label the node")
+	}
+}
+
+// Lists the devices before the next check
+func (s *DisagDevServer) refreshDevices() {
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
 // GetDevicePluginOptions returns options to be communicated with Device Manager
 func (s *DisagDevServer) GetDevicePluginOptions(ctx context.Context, e *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
 	return &pluginapi.DevicePluginOptions{PreStartRequired: true}, nil
@@ -139,7 +214,7 @@ func (s *DisagDevServer) ListAndWatch(e *pluginapi.Empty, srv pluginapi.DevicePl
 	}
 
 	if err := srv.Send(&pluginapi.ListAndWatchResponse{Devices: devs}); err != nil {
-		log.Errorf("Failed to send devices: %v", err)
+		klog.ErrorS(err, "Failed to send devices")
 		return err
 	}
 
@@ -169,12 +244,16 @@ func (s *DisagDevServer) ListAndWatch(e *pluginapi.Empty, srv pluginapi.DevicePl
 
 			if !reflect.DeepEqual(devs, old_devs) {
 				if err := srv.Send(&pluginapi.ListAndWatchResponse{Devices: devs}); err != nil {
-					log.Errorf("Failed to send updated device list: %v", err)
+					klog.ErrorS(err, "Failed to send updated device list")
 				}
-				copy(old_devs, devs)
+				nodeLogger().V(2).Info("Advertised devices", "hostport", s.devIF.HostPort(), "devids", keys)
+				old_devs = devs
 			}
 		}
-		<-time.After(s.deviceCheckInterval)
+		select {
+		case <-time.After(s.deviceCheckInterval):
+		case <-s.refresh:
+		}
 	}
 }
 
@@ -182,10 +261,12 @@ func (s *DisagDevServer) ListAndWatch(e *pluginapi.Empty, srv pluginapi.DevicePl
 // Plugin can run device specific operations and instruct Kubelet
 // of the steps to make the Device available in the container
 func (s *DisagDevServer) Allocate(ctx context.Context, reqs *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
-	log.Infoln("Allocate called")
+	logger := nodeLogger().WithValues("hostport", s.devIF.HostPort())
 	resps := &pluginapi.AllocateResponse{}
 	for _, req := range reqs.ContainerRequests {
-		log.Infof("Received request: %v", strings.Join(req.DevicesIDs, ","))
+		logger.Info("Allocating devices", "devids", req.DevicesIDs)
+		allocations.Inc()
+		allocatedDevices.Add(float64(len(req.DevicesIDs)))
 		gpuIDs := make([]string, len(req.DevicesIDs))
 		for i, devID := range req.DevicesIDs {
 			gpuIDs[i] = s.gpuLookUp[devID]
@@ -215,15 +296,25 @@ func (s *DisagDevServer) GetPreferredAllocation(context.Context, *v1beta1.Prefer
 
 func (s *DisagDevServer) listDevice() error {
 	s.devices = make(map[string]*pluginapi.Device)
-	devices, _ := s.devIF.GetResource()
+	devices, err := s.devIF.GetResource()
+	if err != nil {
+		poolAPIRequests.WithLabelValues("get_resources", "error").Inc()
+	} else {
+		poolAPIRequests.WithLabelValues("get_resources", "success").Inc()
+	}
 
+	// The devices reserved by the DRA driver are attached for its claims, kubelet hands them to the pods through the driver
 	for _, dp := range devices {
+		if s.reserved.has(dp.DevID) {
+			continue
+		}
 		s.gpuLookUp[dp.DevID] = dp.GpuUUID
 		s.devices[dp.GpuUUID] = &pluginapi.Device{
 			ID:     dp.DevID,
 			Health: pluginapi.Healthy,
 		}
 	}
+	advertisedDevices.Set(float64(len(s.devices)))
 	return nil
 }
 
@@ -1,16 +1,16 @@
 package main
 
 import (
-	"log"
 	"my-scheduler-plugins/pkg/falconresources"
 	"os"
 
 	"k8s.io/component-base/cli"
+	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/cmd/kube-scheduler/app"
 )
 
 func main() {
-	log.Printf("falconresources-scheduler starts!\n")
+	klog.InfoS("falconresources-scheduler starts")
 	command := app.NewSchedulerCommand(
 		app.WithPlugin(falconresources.Name, falconresources.New),
 	)
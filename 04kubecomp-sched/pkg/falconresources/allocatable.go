@@ -0,0 +1,58 @@
+package falconresources
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// allocatableNotifier wakes up the pods waiting in Permit when the GPUs allocatable on a node change
+type allocatableNotifier struct {
+	sync.Mutex
+	changed chan struct{}
+}
+
+func newAllocatableNotifier() *allocatableNotifier {
+	return &allocatableNotifier{
+		changed: make(chan struct{}),
+	}
+}
+
+// Returns a channel which is closed on the next change of allocatable GPUs
+func (n *allocatableNotifier) wait() <-chan struct{} {
+	n.Lock()
+	defer n.Unlock()
+	return n.changed
+}
+
+func (n *allocatableNotifier) notify() {
+	n.Lock()
+	defer n.Unlock()
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// Returns the event handlers which notify on the changes of allocatable GPUs
+func (n *allocatableNotifier) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			n.notify()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*v1.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := newObj.(*v1.Node)
+			if !ok {
+				return
+			}
+			oldAlloc := oldNode.Status.Allocatable["falcon.com/gpu"]
+			newAlloc := newNode.Status.Allocatable["falcon.com/gpu"]
+			if !oldAlloc.Equal(newAlloc) {
+				n.notify()
+			}
+		},
+	}
+}
@@ -0,0 +1,26 @@
+package falconresources
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// Reasons of the events recorded on pods, shown by `kubectl describe pod`
+const (
+	reasonInsufficientPool    string = "InsufficientPoolGPU" // the pool cannot provide the requested GPUs
+	reasonReconfig            string = "Reconfig"
+	reasonReconfigCompleted   string = "ReconfigCompleted"
+	reasonReconfigTimeout     string = "ReconfigTimeout"
+	reasonPodGroupIncomplete  string = "PodGroupIncomplete"
+	reasonPreempted           string = "Preempted"
+	actionScheduling          string = "Scheduling"
+	actionReconfiguring       string = "Reconfiguring"
+	actionPreempting          string = "Preempting"
+	reconfigTroubleshootHints string = "check the reconfig-mgr logs and the resource pool"
+)
+
+// Records a warning on the pod and returns the status rejecting it in PreFilter
+func (gp *FalconResources) rejectPod(pod *v1.Pod, code framework.Code, reason string, note string) *framework.Status {
+	gp.handle.EventRecorder().Eventf(pod, nil, v1.EventTypeWarning, reason, actionScheduling, "%s", note)
+	return framework.NewStatus(code, note)
+}
@@ -4,43 +4,87 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"math"
 	"strconv"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
-	typedv1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/scheduler/framework"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
 )
 
 // FalconResources is a plugin that see the GPU as a composable device
 type FalconResources struct {
-	handle framework.Handle
-	k8scli *kubernetes.Clientset
+	handle     framework.Handle
+	k8scli     kubernetes.Interface
+	gangs      *gangTracker
+	topology   *poolTopology
+	unassigned *unassignedPool
+	allocs     *allocatableNotifier
+}
+
+// FalconResourcesArgs holds the arguments used to configure the plugin
+type FalconResourcesArgs struct {
+	// Namespace and name of the ConfigMap holding topology.yaml, which describes the nodes cabled to each pool
+	TopologyNamespace string `json:"topologyNamespace,omitempty"`
+	TopologyConfigMap string `json:"topologyConfigMap,omitempty"`
+	// Name of the ConfigMap in TopologyNamespace where reconfig-mgr publishes the GPUs not attached to any host
+	UnassignedConfigMap string `json:"unassignedConfigMap,omitempty"`
+	// host:port of the OTLP gRPC collector receiving the traces of the reconfigurations, empty disables the export
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+}
+
+// preFilterState keeps the free GPUs of every node computed in PreFilter
+type preFilterState struct {
+	freeFalcon       map[string]int64
+	unassignedFalcon map[string]int64 // free GPUs not attached to any node which each node can reach in its pools
+}
+
+func (s *preFilterState) Clone() framework.StateData {
+	return s
 }
 
 var _ framework.PreFilterPlugin = &FalconResources{}
+var _ framework.FilterPlugin = &FalconResources{}
 var _ framework.ScorePlugin = &FalconResources{}
 var _ framework.PermitPlugin = &FalconResources{}
+var _ framework.ReservePlugin = &FalconResources{}
 
 const (
-	Name                  string = "FalconResources" // name of the plugin used in Registry and configurations
-	perDeviceReconfigTime int    = 5
+	Name                  string             = "FalconResources" // name of the plugin used in Registry and configurations
+	perDeviceReconfigTime int                = 5
+	preFilterStateKey     framework.StateKey = "PreFilter" + framework.StateKey(Name)
 )
 
 func (gp *FalconResources) Name() string {
 	return Name
 }
 
+// Returns the time given to reconfig-mgr to move the demanded GPUs
+func reconfigWaitTime(demand int) time.Duration {
+	return time.Duration(15+demand*perDeviceReconfigTime) * time.Second
+}
+
 // Initializes and returns a new FalconResources plugin
-func New(_ runtime.Object, h framework.Handle) (framework.Plugin, error) {
+func New(obj runtime.Object, h framework.Handle) (framework.Plugin, error) {
+	args := FalconResourcesArgs{
+		TopologyNamespace:   "kubecomp",
+		TopologyConfigMap:   "falcon-topo",
+		UnassignedConfigMap: "pool-unassigned",
+	}
+	if err := frameworkruntime.DecodeInto(obj, &args); err != nil {
+		return nil, fmt.Errorf("failed to decode plugin args: %v", err)
+	}
+
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get in-cluster config: %v", err)
@@ -51,25 +95,57 @@ func New(_ runtime.Object, h framework.Handle) (framework.Plugin, error) {
 		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
 	}
 
+	registerPluginMetrics()
+	if err := setupTracing(args.OTLPEndpoint); err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %v", err)
+	}
+
+	allocs := newAllocatableNotifier()
+	if _, err := h.SharedInformerFactory().Core().V1().Nodes().Informer().AddEventHandler(allocs.eventHandler()); err != nil {
+		return nil, fmt.Errorf("failed to add node event handler: %v", err)
+	}
+
+	pools, err := newPoolTopology(context.Background(), k8scli, h.SharedInformerFactory().Core().V1().Nodes().Informer(), args.TopologyNamespace, args.TopologyConfigMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add node topology event handler: %v", err)
+	}
+
 	return &FalconResources{
-		handle: h,
-		k8scli: k8scli,
+		handle:     h,
+		k8scli:     k8scli,
+		gangs:      newGangTracker(),
+		topology:   pools,
+		unassigned: newUnassignedPool(context.Background(), k8scli, args.TopologyNamespace, args.UnassignedConfigMap),
+		allocs:     allocs,
 	}, nil
 }
 
 // Filters the pod if the gpu count in the "gpu pool" is less than the required amount
 func (gp *FalconResources) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) (*framework.PreFilterResult, *framework.Status) {
 	// If the required GPUs exceed the available GPUs, return failure early.
-	requiredFalconQuantity := pod.Spec.Containers[0].Resources.Requests["falcon.com/gpu"]
-	requiredFalcon, _ := requiredFalconQuantity.AsInt64()
+	requiredFalcon := getFalconRequest(pod)
 
 	nodeinfos, _ := gp.handle.SnapshotSharedLister().NodeInfos().List()
 	totalFalcon := int64(0)
+	freeFalcon := make(map[string]int64, len(nodeinfos))
 	for _, nodeinfo := range nodeinfos {
-		totalFalcon += (nodeinfo.Allocatable.ScalarResources["falcon.com/gpu"] - nodeinfo.Requested.ScalarResources["falcon.com/gpu"])
+		freeFalcon[nodeinfo.Node().Name] = (nodeinfo.Allocatable.ScalarResources["falcon.com/gpu"] - nodeinfo.Requested.ScalarResources["falcon.com/gpu"])
+		totalFalcon += freeFalcon[nodeinfo.Node().Name]
+	}
+	totalFalcon += gp.unassigned.falcon(nil)
+
+	// A node can only gain GPUs from the pools its host port is cabled to, and from the unassigned GPUs of these pools
+	poolFalcon := int64(0)
+	unassignedFalcon := make(map[string]int64, len(freeFalcon))
+	for nodeName := range freeFalcon {
+		unassignedFalcon[nodeName] = gp.unassigned.falcon(gp.topology.poolsOf(nodeName))
+		if reachable := gp.topology.reachableFalcon(nodeName, freeFalcon) + unassignedFalcon[nodeName]; reachable > poolFalcon {
+			poolFalcon = reachable
+		}
 	}
+	state.Write(preFilterStateKey, &preFilterState{freeFalcon: freeFalcon, unassignedFalcon: unassignedFalcon})
 
-	log.Printf("Pod %s requires %d GPU(s), and currently has %d GPU(s) in total\n", pod.Name, requiredFalcon, totalFalcon)
+	klog.FromContext(ctx).V(4).Info("GPUs in the pool", "pod", klog.KObj(pod), "required", requiredFalcon, "total", totalFalcon, "largestPool", poolFalcon)
 
 	patchAnnotations := map[string]interface{}{
 		"metadata": map[string]map[string]string{
@@ -92,8 +168,28 @@ func (gp *FalconResources) PreFilter(ctx context.Context, state *framework.Cycle
 
 	// Total gpu is less than required, so it's useless to reconfigure
 	if totalFalcon < requiredFalcon {
-		reason := fmt.Sprintf("Pod %s requires %d GPU but only %d GPU in the pool.", pod.Name, requiredFalcon, totalFalcon)
-		return nil, framework.NewStatus(framework.Unschedulable, reason)
+		reason := fmt.Sprintf("Pod %s requires %d GPU but only %d GPU in the pool. Free GPUs by removing pods or lower the request.", pod.Name, requiredFalcon, totalFalcon)
+		return nil, gp.rejectPod(pod, framework.Unschedulable, reasonInsufficientPool, reason)
+	}
+	if poolFalcon < requiredFalcon {
+		reason := fmt.Sprintf("Pod %s requires %d GPU but at most %d GPU can be composed to a single node. Check the pool topology or lower the request.", pod.Name, requiredFalcon, poolFalcon)
+		return nil, gp.rejectPod(pod, framework.Unschedulable, reasonInsufficientPool, reason)
+	}
+
+	// The whole pod group must fit in the pool, otherwise none of its members is placed
+	if group, minMember, ok := getPodGroup(pod); ok {
+		gangFalcon, members, err := gp.getGangRequest(pod)
+		if err != nil {
+			return nil, framework.AsStatus(fmt.Errorf("failed to list pod group %s: %v", group, err))
+		}
+		if members < minMember {
+			reason := fmt.Sprintf("Pod group %s has %d member(s) but requires at least %d. Create the remaining members or lower %s.", group, members, minMember, podGroupMinMemberLabel)
+			return nil, gp.rejectPod(pod, framework.UnschedulableAndUnresolvable, reasonPodGroupIncomplete, reason)
+		}
+		if totalFalcon < gangFalcon {
+			reason := fmt.Sprintf("Pod group %s requires %d GPU but only %d GPU in the pool. Free GPUs by removing pods or shrink the group.", group, gangFalcon, totalFalcon)
+			return nil, gp.rejectPod(pod, framework.Unschedulable, reasonInsufficientPool, reason)
+		}
 	}
 
 	if err := gp.patchPodAnnotations(ctx, pod.Namespace, pod.Name, patchAnnotations); err != nil {
@@ -108,6 +204,30 @@ func (gp *FalconResources) PreFilterExtensions() framework.PreFilterExtensions {
 	return nil
 }
 
+// Filters the node if the GPUs reachable from its host port are less than the required amount
+func (gp *FalconResources) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	requiredFalcon := getFalconRequest(pod)
+	if requiredFalcon == 0 {
+		return nil
+	}
+
+	data, err := state.Read(preFilterStateKey)
+	if err != nil {
+		return framework.AsStatus(fmt.Errorf("failed to read %q from cycleState: %v", preFilterStateKey, err))
+	}
+	s, ok := data.(*preFilterState)
+	if !ok {
+		return framework.AsStatus(fmt.Errorf("%+v cannot be converted to preFilterState", data))
+	}
+
+	nodeName := nodeInfo.Node().Name
+	if reachable := gp.topology.reachableFalcon(nodeName, s.freeFalcon) + s.unassignedFalcon[nodeName]; reachable < requiredFalcon {
+		reason := fmt.Sprintf("Node %s can reach only %d GPU in its pools.", nodeName, reachable)
+		return framework.NewStatus(framework.Unschedulable, reason)
+	}
+	return nil
+}
+
 // Invokes at the score extension point
 func (gp *FalconResources) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) (int64, *framework.Status) {
 	nodeInfo, err := gp.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
@@ -115,8 +235,7 @@ func (gp *FalconResources) Score(ctx context.Context, state *framework.CycleStat
 		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("failed to get node %q from Snapshot: %v", nodeName, err))
 	}
 
-	requiredFalconQuantity := pod.Spec.Containers[0].Resources.Requests["falcon.com/gpu"]
-	requiredFalcon, _ := requiredFalconQuantity.AsInt64()
+	requiredFalcon := getFalconRequest(pod)
 	localFalcon := (nodeInfo.Allocatable.ScalarResources["falcon.com/gpu"] - nodeInfo.Requested.ScalarResources["falcon.com/gpu"])
 
 	var score int64 = 0
@@ -128,7 +247,7 @@ func (gp *FalconResources) Score(ctx context.Context, state *framework.CycleStat
 		score = localFalcon - requiredFalcon
 	}
 
-	log.Printf("Node %s has %d GPU(s), %s requires %d GPU(s) -> score: %d", nodeName, localFalcon, pod.Name, requiredFalcon, score)
+	klog.FromContext(ctx).V(5).Info("Scored node", "pod", klog.KObj(pod), "node", nodeName, "local", localFalcon, "required", requiredFalcon, "score", score)
 	return score, nil
 }
 
@@ -163,10 +282,16 @@ func (gp *FalconResources) ScoreExtensions() framework.ScoreExtensions {
 	return gp
 }
 
-func (gp *FalconResources) getGpuDemand(ctx context.Context, pod *v1.Pod, nodeName string) int {
-	node, err := gp.k8scli.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+func (gp *FalconResources) getGpuDemand(pod *v1.Pod, nodeName string) int {
+	return gp.getNodeShortage(nodeName, getFalconRequest(pod))
+}
+
+// Returns how many GPUs the node lacks if extra GPUs are requested on top of the current requests.
+// The allocatable GPUs are read from the informer cache, which follows the reconfiguration of the node.
+func (gp *FalconResources) getNodeShortage(nodeName string, extra int64) int {
+	node, err := gp.handle.SharedInformerFactory().Core().V1().Nodes().Lister().Get(nodeName)
 	if err != nil {
-		log.Printf("failed to get node %q: %v", nodeName, err)
+		klog.ErrorS(err, "Failed to get node", "node", nodeName)
 		return 0
 	}
 
@@ -175,14 +300,12 @@ func (gp *FalconResources) getGpuDemand(ctx context.Context, pod *v1.Pod, nodeNa
 
 	nodeInfo, err := gp.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
 	if err != nil {
-		fmt.Sprintf("getting node %q from Snapshot: %v", nodeName, err)
+		klog.ErrorS(err, "Failed to get node from Snapshot", "node", nodeName)
 		return 0
 	}
 	requestGPU := nodeInfo.Requested.ScalarResources["falcon.com/gpu"]
-	requiredFalconQuantity := pod.Spec.Containers[0].Resources.Requests["falcon.com/gpu"]
-	requiredFalcon, _ := requiredFalconQuantity.AsInt64()
 
-	demand := requiredFalcon - (allocGPU - requestGPU)
+	demand := extra - (allocGPU - requestGPU)
 	if demand > 0 {
 		return int(demand)
 	}
@@ -190,43 +313,78 @@ func (gp *FalconResources) getGpuDemand(ctx context.Context, pod *v1.Pod, nodeNa
 }
 
 func (gp *FalconResources) Permit(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) (*framework.Status, time.Duration) {
+	// The trace of the reconfiguration starts here and continues in reconfig-mgr and the pool
+	ctx, span := tracer.Start(ctx, "FalconResources.Permit",
+		trace.WithAttributes(attribute.String("pod", pod.Namespace+"/"+pod.Name), attribute.String("node", nodeName)))
+	defer span.End()
+
+	if group, minMember, ok := getPodGroup(pod); ok {
+		return gp.permitGang(ctx, pod, nodeName, group, minMember)
+	}
+
 	retStatus := framework.NewStatus(framework.Success)
 	waitTime := time.Duration(0)
 
-	demand := gp.getGpuDemand(ctx, pod, nodeName)
+	demand := gp.getGpuDemand(pod, nodeName)
 	if demand <= 0 {
 		return retStatus, waitTime
 	}
 
+	span.SetAttributes(attribute.Int("demand", demand))
 	retStatus = framework.NewStatus(framework.Unschedulable)
-	// Annotates destination node with demand info
+	// Annotates destination node with demand info and the trace context, reconfig-mgr reacts on the update of the pod.
+	// The attempt time changes the pod on every attempt, even with the same demand.
+	annotations := map[string]string{
+		"dst_node":         nodeName,
+		"gpu_demand":       strconv.Itoa(demand),
+		"reconfig_attempt": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	injectTraceContext(ctx, annotations)
 	patchAnnotations := map[string]interface{}{
 		"metadata": map[string]map[string]string{
-			"annotations": {
-				"dst_node":   nodeName,
-				"gpu_demand": strconv.Itoa(demand),
-			},
+			"annotations": annotations,
 		},
 	}
 
+	logger := requestLogger(ctx).WithValues("pod", klog.KObj(pod), "node", nodeName)
 	if err := gp.patchPodAnnotations(ctx, pod.Namespace, pod.Name, patchAnnotations); err != nil {
+		logger.Error(err, "Failed to patch pod annotations")
 		return framework.NewStatus(framework.Error, "failed to patch pod annotations"), waitTime
 	}
+	logger.Info("Pod needs reconfiguration", "demand", demand)
 
-	// Log and create an event indicating the pod needs reconfiguration
-	gp.createPodEvent(pod, "Reconfig", fmt.Sprintf("Pod %v needs reconfiguration", pod.Name))
+	// The event is only shown by `kubectl describe pod`, repeated events are folded into a series
+	gp.handle.EventRecorder().Eventf(pod, nil, v1.EventTypeNormal, reasonReconfig, actionReconfiguring,
+		"Pod %v needs %d more GPU(s) on node %s, waiting for reconfig-mgr to move them", pod.Name, demand, nodeName)
 
 	// Wait for the reconfiguration to complete within a set time frame
-	startTime := time.Now()
-	for time.Since(startTime) <= (time.Duration(15+demand*perDeviceReconfigTime))*time.Second {
-		num := gp.getGpuDemand(ctx, pod, nodeName)
-		if num == 0 {
+	reconfigTime := reconfigWaitTime(demand)
+	timeout := time.After(reconfigTime)
+	start := time.Now()
+	for {
+		changed := gp.allocs.wait()
+		if gp.getGpuDemand(pod, nodeName) == 0 {
+			observePermitWait("pod", permitSatisfied, start)
+			logger.Info("Node is reconfigured", "waited", time.Since(start))
+			gp.handle.EventRecorder().Eventf(pod, nil, v1.EventTypeNormal, reasonReconfigCompleted, actionReconfiguring,
+				"Node %s now has enough GPUs for pod %v", nodeName, pod.Name)
 			return framework.NewStatus(framework.Success), waitTime
 		}
-		time.Sleep(1 * time.Second)
+		select {
+		case <-changed:
+		case <-timeout:
+			observePermitWait("pod", permitTimeout, start)
+			logger.Info("Reconfiguration timed out", "waited", time.Since(start), "missing", gp.getGpuDemand(pod, nodeName))
+			span.SetStatus(codes.Error, "reconfiguration timed out")
+			gp.handle.EventRecorder().Eventf(pod, nil, v1.EventTypeWarning, reasonReconfigTimeout, actionReconfiguring,
+				"Node %s still lacks %d GPU(s) after %v, %s. The pod will be retried.", nodeName, gp.getGpuDemand(pod, nodeName), reconfigTime, reconfigTroubleshootHints)
+			return retStatus, waitTime
+		case <-ctx.Done():
+			observePermitWait("pod", permitCanceled, start)
+			span.SetStatus(codes.Error, "canceled")
+			return retStatus, waitTime
+		}
 	}
-
-	return retStatus, waitTime
 }
 
 // Helper function to patch pod annotations
@@ -238,15 +396,3 @@ func (gp *FalconResources) patchPodAnnotations(ctx context.Context, namespace, p
 	_, err = gp.k8scli.CoreV1().Pods(namespace).Patch(ctx, podName, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
 	return err
 }
-
-func (gp *FalconResources) createPodEvent(pod *v1.Pod, reason, message string) {
-	scheme := runtime.NewScheme()
-	_ = v1.AddToScheme(scheme)
-
-	eventBroadcaster := record.NewBroadcaster()
-	defer eventBroadcaster.Shutdown()
-	eventBroadcaster.StartStructuredLogging(0)
-	eventBroadcaster.StartRecordingToSink(&typedv1core.EventSinkImpl{Interface: gp.k8scli.CoreV1().Events("")})
-	eventRecorder := eventBroadcaster.NewRecorder(scheme, v1.EventSource{})
-	eventRecorder.Event(pod, v1.EventTypeNormal, reason, message)
-}
@@ -0,0 +1,268 @@
+package falconresources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+const (
+	podGroupLabel          string = "falcon.com/pod-group"            // name of the pod group the pod belongs to
+	podGroupMinMemberLabel string = "falcon.com/pod-group-min-member" // number of members that must be placed together
+	gangWaitTime           int    = 60                                // seconds a member waits in Permit for the rest of its group, before the reconfiguration
+)
+
+// gangState tracks the members of a pod group that have reached Permit
+type gangState struct {
+	minMember int
+	members   map[types.UID]string // member UID to the node it was assigned to
+}
+
+// gangTracker keeps the pod groups that are waiting in Permit
+type gangTracker struct {
+	sync.Mutex
+	groups map[string]*gangState
+}
+
+func newGangTracker() *gangTracker {
+	return &gangTracker{
+		groups: make(map[string]*gangState),
+	}
+}
+
+// Returns the group key and min member count if the pod belongs to a pod group
+func getPodGroup(pod *v1.Pod) (string, int, bool) {
+	group, ok := pod.Labels[podGroupLabel]
+	if !ok || group == "" {
+		return "", 0, false
+	}
+	minMember, err := strconv.Atoi(pod.Labels[podGroupMinMemberLabel])
+	if err != nil || minMember < 1 {
+		minMember = 1
+	}
+	return pod.Namespace + "/" + group, minMember, true
+}
+
+// Returns the number of falcon GPUs required by the pod
+func getFalconRequest(pod *v1.Pod) int64 {
+	requiredFalconQuantity := pod.Spec.Containers[0].Resources.Requests["falcon.com/gpu"]
+	requiredFalcon, _ := requiredFalconQuantity.AsInt64()
+	return requiredFalcon
+}
+
+// Returns the GPUs the whole gang still needs from the pool and the number of known members
+func (gp *FalconResources) getGangRequest(pod *v1.Pod) (int64, int, error) {
+	selector := labels.SelectorFromSet(labels.Set{podGroupLabel: pod.Labels[podGroupLabel]})
+	pods, err := gp.handle.SharedInformerFactory().Core().V1().Pods().Lister().Pods(pod.Namespace).List(selector)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	members := 0
+	required := int64(0)
+	for _, po := range pods {
+		if po.Status.Phase == v1.PodSucceeded || po.Status.Phase == v1.PodFailed {
+			continue
+		}
+		members++
+		// Bound members are already counted in the requested GPUs of their nodes
+		if po.Spec.NodeName == "" {
+			required += getFalconRequest(po)
+		}
+	}
+	return required, members, nil
+}
+
+// Holds the gang member until the whole group reaches Permit, then reconfigures the pool for the group at once
+func (gp *FalconResources) permitGang(ctx context.Context, pod *v1.Pod, nodeName string, group string, minMember int) (*framework.Status, time.Duration) {
+	gp.gangs.Lock()
+	gang, ok := gp.gangs.groups[group]
+	if !ok {
+		gang = &gangState{minMember: minMember, members: make(map[types.UID]string)}
+		gp.gangs.groups[group] = gang
+	}
+	gang.members[pod.UID] = nodeName
+
+	// Drops the members which are no longer waiting, e.g. timed out in Permit
+	for uid := range gang.members {
+		if uid != pod.UID && gp.handle.GetWaitingPod(uid) == nil {
+			delete(gang.members, uid)
+		}
+	}
+
+	if waiting := len(gang.members); waiting < gang.minMember {
+		gp.gangs.Unlock()
+		// The member also waits while the last one reconfigures the pool for the group, which takes longer with the demand
+		required, _, err := gp.getGangRequest(pod)
+		if err != nil {
+			required = getFalconRequest(pod) * int64(minMember)
+		}
+		wait := time.Duration(gangWaitTime)*time.Second + reconfigWaitTime(int(required))
+		klog.FromContext(ctx).V(2).Info("Pod waits for its pod group", "pod", klog.KObj(pod), "podGroup", group, "members", waiting, "minMember", minMember, "timeout", wait)
+		return framework.NewStatus(framework.Wait), wait
+	}
+
+	members := make(map[types.UID]string, len(gang.members))
+	for uid, node := range gang.members {
+		members[uid] = node
+	}
+	delete(gp.gangs.groups, group)
+	gp.gangs.Unlock()
+
+	status := gp.reconfigGang(ctx, pod, nodeName, group, members)
+	for uid := range members {
+		if uid == pod.UID {
+			continue
+		}
+		if waitingPod := gp.handle.GetWaitingPod(uid); waitingPod != nil {
+			if status.IsSuccess() {
+				waitingPod.Allow(Name)
+			} else {
+				waitingPod.Reject(Name, status.Message())
+			}
+		}
+	}
+	return status, 0
+}
+
+// Builds one reconfiguration plan for all members of the group and waits until it is satisfied
+func (gp *FalconResources) reconfigGang(ctx context.Context, pod *v1.Pod, nodeName string, group string, members map[types.UID]string) *framework.Status {
+	plan := make(map[string]int)
+	for _, node := range members {
+		plan[node] = 0
+	}
+
+	// Earlier members are already assumed on their nodes, so only the current pod is added on top
+	totalDemand := 0
+	for node := range plan {
+		extra := int64(0)
+		if node == nodeName {
+			extra = getFalconRequest(pod)
+		}
+		plan[node] = gp.getNodeShortage(node, extra)
+		totalDemand += plan[node]
+	}
+
+	if totalDemand == 0 {
+		return framework.NewStatus(framework.Success)
+	}
+
+	// GPUs can only be taken from nodes outside the plan whose pools are cabled to a node in the plan
+	planNodes := make([]string, 0, len(plan))
+	for node := range plan {
+		planNodes = append(planNodes, node)
+	}
+	reachable := gp.topology.reachableFrom(planNodes...)
+	nodeinfos, _ := gp.handle.SnapshotSharedLister().NodeInfos().List()
+	spareFalcon := gp.unassigned.falcon(gp.topology.poolsOf(planNodes...))
+	for _, nodeinfo := range nodeinfos {
+		if _, ok := plan[nodeinfo.Node().Name]; ok {
+			continue
+		}
+		if !reachable(nodeinfo.Node().Name) {
+			continue
+		}
+		spareFalcon += (nodeinfo.Allocatable.ScalarResources["falcon.com/gpu"] - nodeinfo.Requested.ScalarResources["falcon.com/gpu"])
+	}
+	if spareFalcon < int64(totalDemand) {
+		reason := fmt.Sprintf("Pod group %s requires %d more GPU but only %d GPU can be moved. Free GPUs by removing pods or shrink the group.", group, totalDemand, spareFalcon)
+		gp.handle.EventRecorder().Eventf(pod, nil, v1.EventTypeWarning, reasonInsufficientPool, actionReconfiguring, "%s", reason)
+		return framework.NewStatus(framework.Unschedulable, reason)
+	}
+
+	planBytes, err := json.Marshal(plan)
+	if err != nil {
+		return framework.AsStatus(fmt.Errorf("failed to marshal gang plan: %v", err))
+	}
+	annotations := map[string]string{
+		"gang_plan":        string(planBytes),
+		"reconfig_attempt": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	injectTraceContext(ctx, annotations)
+	patchAnnotations := map[string]interface{}{
+		"metadata": map[string]map[string]string{
+			"annotations": annotations,
+		},
+	}
+	if err := gp.patchPodAnnotations(ctx, pod.Namespace, pod.Name, patchAnnotations); err != nil {
+		return framework.NewStatus(framework.Error, "failed to patch pod annotations")
+	}
+
+	logger := requestLogger(ctx).WithValues("pod", klog.KObj(pod), "podGroup", group)
+	logger.Info("Pod group needs reconfiguration", "plan", string(planBytes))
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("pod_group", group), attribute.String("plan", string(planBytes)))
+	gp.handle.EventRecorder().Eventf(pod, nil, v1.EventTypeNormal, reasonReconfig, actionReconfiguring,
+		"Pod group %v needs reconfiguration: %s", group, string(planBytes))
+
+	// Wait for the reconfiguration of every node to complete within a set time frame
+	// The other members wait at least as long, see permitGang
+	timeout := time.After(reconfigWaitTime(totalDemand))
+	start := time.Now()
+	for {
+		changed := gp.allocs.wait()
+		satisfied := true
+		for node := range plan {
+			extra := int64(0)
+			if node == nodeName {
+				extra = getFalconRequest(pod)
+			}
+			if gp.getNodeShortage(node, extra) > 0 {
+				satisfied = false
+				break
+			}
+		}
+		if satisfied {
+			observePermitWait("gang", permitSatisfied, start)
+			logger.Info("Pod group is reconfigured", "waited", time.Since(start))
+			gp.handle.EventRecorder().Eventf(pod, nil, v1.EventTypeNormal, reasonReconfigCompleted, actionReconfiguring,
+				"Nodes of pod group %v now have enough GPUs", group)
+			return framework.NewStatus(framework.Success)
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			observePermitWait("gang", permitTimeout, start)
+			logger.Info("Reconfiguration of the pod group timed out", "waited", time.Since(start))
+			trace.SpanFromContext(ctx).SetStatus(codes.Error, "reconfiguration timed out")
+			reason := fmt.Sprintf("Reconfiguration for pod group %s timed out, %s. The group will be retried.", group, reconfigTroubleshootHints)
+			gp.handle.EventRecorder().Eventf(pod, nil, v1.EventTypeWarning, reasonReconfigTimeout, actionReconfiguring, "%s", reason)
+			return framework.NewStatus(framework.Unschedulable, reason)
+		case <-ctx.Done():
+			observePermitWait("gang", permitCanceled, start)
+			trace.SpanFromContext(ctx).SetStatus(codes.Error, "canceled")
+			return framework.AsStatus(ctx.Err())
+		}
+	}
+}
+
+// Removes the pod from its pod group when it is rejected or fails to bind
+func (gp *FalconResources) Unreserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+	group, _, ok := getPodGroup(pod)
+	if !ok {
+		return
+	}
+	gp.gangs.Lock()
+	defer gp.gangs.Unlock()
+	if gang, ok := gp.gangs.groups[group]; ok {
+		delete(gang.members, pod.UID)
+		if len(gang.members) == 0 {
+			delete(gp.gangs.groups, group)
+		}
+	}
+}
+
+func (gp *FalconResources) Reserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	return nil
+}
@@ -0,0 +1,39 @@
+package falconresources
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// Results of a wait in Permit, used as the result label of permitWaitDuration
+const (
+	permitSatisfied string = "satisfied"
+	permitTimeout   string = "timeout"
+	permitCanceled  string = "canceled"
+)
+
+var (
+	// Time a pod, or the last member of a pod group, waits in Permit for reconfig-mgr to move the GPUs
+	permitWaitDuration = metrics.NewHistogramVec(&metrics.HistogramOpts{
+		Name:           "kubecomp_permit_wait_duration_seconds",
+		Help:           "Time spent in Permit waiting for the reconfiguration of the pool, by kind (pod or gang) and result.",
+		Buckets:        metrics.ExponentialBuckets(0.5, 2, 10),
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"kind", "result"})
+
+	registerMetrics sync.Once
+)
+
+// Registers the metrics of the plugin, which the scheduler serves at /metrics
+func registerPluginMetrics() {
+	registerMetrics.Do(func() {
+		legacyregistry.MustRegister(permitWaitDuration)
+	})
+}
+
+func observePermitWait(kind string, result string, start time.Time) {
+	permitWaitDuration.WithLabelValues(kind, result).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,247 @@
+package falconresources
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+var _ framework.PostFilterPlugin = &FalconResources{}
+
+// preemptionVictim is a pod holding pooled GPUs which may be evicted
+type preemptionVictim struct {
+	pod      *v1.Pod
+	nodeName string
+	falcon   int64
+}
+
+// Evicts lower-priority pods anywhere in the cluster so that the pool can be recomposed for the pod
+func (gp *FalconResources) PostFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod, filteredNodeStatusMap framework.NodeToStatusMap) (*framework.PostFilterResult, *framework.Status) {
+	if pod.Spec.PreemptionPolicy != nil && *pod.Spec.PreemptionPolicy == v1.PreemptNever {
+		return nil, framework.NewStatus(framework.Unschedulable, "Pod is not allowed to preempt other pods")
+	}
+
+	requiredFalcon := getFalconRequest(pod)
+	if requiredFalcon == 0 {
+		return nil, framework.NewStatus(framework.Unschedulable, "Pod does not use pooled GPUs")
+	}
+	if _, _, ok := getPodGroup(pod); ok {
+		gangFalcon, _, err := gp.getGangRequest(pod)
+		if err != nil {
+			return nil, framework.AsStatus(err)
+		}
+		requiredFalcon = gangFalcon
+	}
+
+	nodeinfos, err := gp.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return nil, framework.AsStatus(err)
+	}
+	if !gp.eligibleToPreempt(pod, nodeinfos, filteredNodeStatusMap) {
+		return nil, framework.NewStatus(framework.Unschedulable, "Pod waits for the GPUs of its preempted victims, which are terminating")
+	}
+
+	// Collects the free GPUs and the lower-priority pods holding GPUs on every node
+	priority := corev1helpers.PodPriority(pod)
+	localFalcon := make(map[string]int64)
+	var candidates []preemptionVictim
+	for _, nodeinfo := range nodeinfos {
+		nodeName := nodeinfo.Node().Name
+		localFalcon[nodeName] = nodeinfo.Allocatable.ScalarResources["falcon.com/gpu"] - nodeinfo.Requested.ScalarResources["falcon.com/gpu"]
+		for _, podInfo := range nodeinfo.Pods {
+			falcon := getFalconRequest(podInfo.Pod)
+			if falcon == 0 || corev1helpers.PodPriority(podInfo.Pod) >= priority || podInfo.Pod.DeletionTimestamp != nil {
+				continue
+			}
+			candidates = append(candidates, preemptionVictim{pod: podInfo.Pod, nodeName: nodeName, falcon: falcon})
+		}
+	}
+
+	// Evicts the lowest priority pods first, and the most recently started ones among the same priority
+	sort.Slice(candidates, func(i, j int) bool {
+		pi, pj := corev1helpers.PodPriority(candidates[i].pod), corev1helpers.PodPriority(candidates[j].pod)
+		if pi != pj {
+			return pi < pj
+		}
+		return candidates[j].pod.CreationTimestamp.Before(&candidates[i].pod.CreationTimestamp)
+	})
+
+	pdbs, err := gp.handle.SharedInformerFactory().Policy().V1().PodDisruptionBudgets().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, framework.AsStatus(fmt.Errorf("failed to list PodDisruptionBudgets: %v", err))
+	}
+
+	// Each node can only gain the GPUs reachable in its pools, so the victims are chosen for every node,
+	// and the node which needs the fewest evictions, then has the most GPUs once they are freed, is nominated
+	nodeNames := make([]string, 0, len(nodeinfos))
+	for _, nodeinfo := range nodeinfos {
+		nodeName := nodeinfo.Node().Name
+		if status, ok := filteredNodeStatusMap[nodeName]; ok && status.Code() == framework.UnschedulableAndUnresolvable {
+			continue
+		}
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+
+	nominatedNode, shortage, available, maxFreed := "", int64(0), int64(0), int64(0)
+	var victims []preemptionVictim
+	for _, nodeName := range nodeNames {
+		reachableFalcon := gp.topology.reachableFalcon(nodeName, localFalcon) + gp.unassigned.falcon(gp.topology.poolsOf(nodeName))
+		nodeShortage := requiredFalcon - reachableFalcon
+		if nodeShortage <= 0 {
+			return nil, framework.NewStatus(framework.Unschedulable, "Pool has enough GPUs, preemption does not help")
+		}
+		nodeVictims, freed := selectVictims(candidates, gp.topology.reachableFrom(nodeName), nodeShortage, pdbs)
+		if freed > maxFreed {
+			maxFreed = freed
+		}
+		if freed < nodeShortage {
+			continue
+		}
+		if nominatedNode == "" || len(nodeVictims) < len(victims) || (len(nodeVictims) == len(victims) && reachableFalcon+freed > available) {
+			nominatedNode, shortage, available, victims = nodeName, nodeShortage, reachableFalcon+freed, nodeVictims
+		}
+	}
+	if nominatedNode == "" {
+		reason := fmt.Sprintf("Pod %s requires %d GPU but at most %d GPU can be preempted within the pools of a node.", pod.Name, requiredFalcon, maxFreed)
+		return nil, framework.NewStatus(framework.Unschedulable, reason)
+	}
+
+	// Evicts nobody unless every victim can be evicted, e.g. a PodDisruptionBudget may have changed since the lister was synced
+	for _, victim := range victims {
+		if err := gp.evictPod(ctx, victim.pod, true); err != nil {
+			reason := fmt.Sprintf("Pod %s/%s cannot be evicted: %v", victim.pod.Namespace, victim.pod.Name, err)
+			return nil, framework.NewStatus(framework.Unschedulable, reason)
+		}
+	}
+
+	// An eviction may still fail meanwhile, the other victims are evicted anyway and the node is only nominated if enough GPUs are freed
+	freed := int64(0)
+	for _, victim := range victims {
+		if err := gp.evictPod(ctx, victim.pod, false); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to evict pod", "pod", klog.KObj(victim.pod), "preemptor", klog.KObj(pod))
+			continue
+		}
+		freed += victim.falcon
+		klog.FromContext(ctx).Info("Pod is preempted", "pod", klog.KObj(victim.pod), "node", victim.nodeName, "preemptor", klog.KObj(pod))
+		gp.handle.EventRecorder().Eventf(victim.pod, pod, v1.EventTypeNormal, reasonPreempted, actionPreempting,
+			"Preempted by %v/%v to free %d pooled GPU(s)", pod.Namespace, pod.Name, victim.falcon)
+	}
+	if freed < shortage {
+		reason := fmt.Sprintf("Pod %s requires %d more GPU but only %d GPU were freed by preemption.", pod.Name, shortage, freed)
+		return nil, framework.NewStatus(framework.Unschedulable, reason)
+	}
+
+	return framework.NewPostFilterResultWithNominatedNode(nominatedNode), framework.NewStatus(framework.Success)
+}
+
+// Like PodEligibleToPreemptOthers of the default preemption, a pod nominated on a node does not preempt again while
+// lower-priority pods holding GPUs are terminating on the nodes reachable from it, their GPUs are about to be freed.
+// A nominated node which became unschedulable and unresolvable does not hold the pod back.
+func (gp *FalconResources) eligibleToPreempt(pod *v1.Pod, nodeinfos []*framework.NodeInfo, filteredNodeStatusMap framework.NodeToStatusMap) bool {
+	nominatedNode := pod.Status.NominatedNodeName
+	if nominatedNode == "" {
+		return true
+	}
+	if status, ok := filteredNodeStatusMap[nominatedNode]; ok && status.Code() == framework.UnschedulableAndUnresolvable {
+		return true
+	}
+
+	reachable := gp.topology.reachableFrom(nominatedNode)
+	priority := corev1helpers.PodPriority(pod)
+	for _, nodeinfo := range nodeinfos {
+		if !reachable(nodeinfo.Node().Name) {
+			continue
+		}
+		for _, podInfo := range nodeinfo.Pods {
+			p := podInfo.Pod
+			if p.DeletionTimestamp != nil && corev1helpers.PodPriority(p) < priority && getFalconRequest(p) > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the first candidates reachable from the node which free the shortage without violating a PodDisruptionBudget,
+// and the GPUs they free
+func selectVictims(candidates []preemptionVictim, reachable func(string) bool, shortage int64, pdbs []*policy.PodDisruptionBudget) ([]preemptionVictim, int64) {
+	disruptionsAllowed := make(map[string]int32)
+	for _, pdb := range pdbs {
+		disruptionsAllowed[pdb.Namespace+"/"+pdb.Name] = pdb.Status.DisruptionsAllowed
+	}
+
+	var victims []preemptionVictim
+	freed := int64(0)
+	for _, candidate := range candidates {
+		if freed >= shortage {
+			break
+		}
+		if !reachable(candidate.nodeName) {
+			continue
+		}
+		matched := matchingPDBs(candidate.pod, pdbs)
+		if violatesPDBs(matched, disruptionsAllowed) {
+			continue
+		}
+		for _, key := range matched {
+			disruptionsAllowed[key]--
+		}
+		victims = append(victims, candidate)
+		freed += candidate.falcon
+	}
+	return victims, freed
+}
+
+// Evicts the pod through the eviction API, so that PodDisruptionBudgets are enforced by the API server.
+// A dry run only checks that the pod can be evicted.
+func (gp *FalconResources) evictPod(ctx context.Context, pod *v1.Pod, dryRun bool) error {
+	eviction := &policy.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	}
+	if dryRun {
+		eviction.DeleteOptions = &metav1.DeleteOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	err := gp.k8scli.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Returns the keys of the PodDisruptionBudgets which select the pod
+func matchingPDBs(pod *v1.Pod, pdbs []*policy.PodDisruptionBudget) []string {
+	var keys []string
+	for _, pdb := range pdbs {
+		if pdb.Namespace != pod.Namespace {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		keys = append(keys, pdb.Namespace+"/"+pdb.Name)
+	}
+	return keys
+}
+
+func violatesPDBs(keys []string, disruptionsAllowed map[string]int32) bool {
+	for _, key := range keys {
+		if disruptionsAllowed[key] <= 0 {
+			return true
+		}
+	}
+	return false
+}
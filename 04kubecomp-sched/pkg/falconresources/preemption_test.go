@@ -0,0 +1,108 @@
+package falconresources
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	fakeframework "k8s.io/kubernetes/pkg/scheduler/framework/fake"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+)
+
+// sharedLister serves the snapshot of the nodes to the plugin
+type sharedLister struct {
+	fakeframework.NodeInfoLister
+}
+
+func (l sharedLister) NodeInfos() framework.NodeInfoLister {
+	return l.NodeInfoLister
+}
+
+func (l sharedLister) StorageInfos() framework.StorageInfoLister {
+	return nil
+}
+
+func makeGPUPod(name string, priority int32, gpus int64, created time.Time) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.NewTime(created)},
+		Spec: v1.PodSpec{
+			Priority: &priority,
+			Containers: []v1.Container{{
+				Name: "main",
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{"falcon.com/gpu": *resource.NewQuantity(gpus, resource.DecimalSI)},
+				},
+			}},
+		},
+	}
+}
+
+// A pod retried while its first victims are still terminating waits for their GPUs instead of evicting more pods
+func TestPostFilterWaitsForTerminatingVictims(t *testing.T) {
+	now := time.Now()
+	low1 := makeGPUPod("low1", 0, 1, now.Add(-2*time.Hour))
+	low2 := makeGPUPod("low2", 0, 1, now.Add(-time.Hour))
+	preemptor := makeGPUPod("high", 100, 1, now)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status:     v1.NodeStatus{Allocatable: v1.ResourceList{"falcon.com/gpu": *resource.NewQuantity(2, resource.DecimalSI)}},
+	}
+	nodeinfo := framework.NewNodeInfo(low1, low2)
+	nodeinfo.SetNode(node)
+
+	client := fake.NewSimpleClientset(low1, low2)
+	var evicted []string
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateAction)
+		if create.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		// Only the real evictions count, the dry runs check that the victims can be evicted
+		eviction := create.GetObject().(*policy.Eviction)
+		if eviction.DeleteOptions == nil || len(eviction.DeleteOptions.DryRun) == 0 {
+			evicted = append(evicted, eviction.Name)
+		}
+		return true, nil, nil
+	})
+
+	fh, err := frameworkruntime.NewFramework(nil, nil, nil,
+		frameworkruntime.WithClientSet(client),
+		frameworkruntime.WithInformerFactory(informers.NewSharedInformerFactory(client, 0)),
+		frameworkruntime.WithSnapshotSharedLister(sharedLister{fakeframework.NodeInfoLister{nodeinfo}}),
+		frameworkruntime.WithEventRecorder(events.NewFakeRecorder(10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gp := &FalconResources{handle: fh, k8scli: client}
+
+	result, status := gp.PostFilter(context.Background(), framework.NewCycleState(), preemptor, framework.NodeToStatusMap{})
+	if !status.IsSuccess() || result == nil || result.NominatedNodeName != "node1" {
+		t.Fatalf("first PostFilter: got %v %v, want node1 nominated", result, status)
+	}
+	if len(evicted) != 1 || evicted[0] != "low2" {
+		t.Fatalf("first PostFilter evicted %v, want [low2]", evicted)
+	}
+
+	// The victim is terminating and the scheduler nominated the node, the pod is retried
+	terminating := metav1.NewTime(now)
+	low2.DeletionTimestamp = &terminating
+	preemptor.Status.NominatedNodeName = result.NominatedNodeName
+	_, status = gp.PostFilter(context.Background(), framework.NewCycleState(), preemptor, framework.NodeToStatusMap{})
+	if status.Code() != framework.Unschedulable {
+		t.Errorf("second PostFilter: got %v, want Unschedulable", status)
+	}
+	if len(evicted) != 1 {
+		t.Errorf("second PostFilter evicted %v, want no new victim", evicted[1:])
+	}
+}
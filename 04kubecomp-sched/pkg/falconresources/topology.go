@@ -0,0 +1,207 @@
+package falconresources
+
+import (
+	"context"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"kubecomp/topology"
+)
+
+const poolLabel string = "falcon.com/pool" // pool the host port of the node is cabled to, labeled by the device plugin
+
+const topologyKey string = "topology.yaml" // key of the topology ConfigMap shared with the device plugin and reconfig-mgr
+
+// poolTopology caches which nodes are cabled to which resource pools.
+// The pools are read from the topology shared with the device plugin and reconfig-mgr,
+// and the nodes labeled with a pool by the device plugin are added to them.
+type poolTopology struct {
+	sync.RWMutex
+	name       string
+	configured []topology.HostPort         // host ports of the last valid topology
+	labeled    map[string]string           // node name to the pool of its label
+	nodePools  map[string]sets.Set[string] // node name to the pools its host port is cabled to
+	poolNodes  map[string]sets.Set[string] // pool name to the nodes cabled to it
+}
+
+// Starts a namespaced informer on the topology ConfigMap and keeps the cache up to date, as well as with the pool labels of the nodes
+func newPoolTopology(ctx context.Context, client kubernetes.Interface, nodeInformer cache.SharedIndexInformer, namespace string, name string) (*poolTopology, error) {
+	t := &poolTopology{
+		name:      name,
+		labeled:   make(map[string]string),
+		nodePools: make(map[string]sets.Set[string]),
+		poolNodes: make(map[string]sets.Set[string]),
+	}
+
+	if _, err := nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			t.updateNode(obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			t.updateNode(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*v1.Node); ok {
+				t.setLabel(node.Name, "")
+			}
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			t.update(obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			t.update(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if cm, ok := obj.(*v1.ConfigMap); ok && cm.Name == t.name {
+				t.set(nil)
+			}
+		},
+	})
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	return t, nil
+}
+
+// Parses the topology of the ConfigMap, an invalid topology is logged and the last valid one is kept
+func (t *poolTopology) update(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok || cm.Name != t.name {
+		return
+	}
+	topo, err := topology.Parse([]byte(cm.Data[topologyKey]))
+	if err != nil {
+		klog.ErrorS(err, "Invalid topology, keeping the last valid one", "configMap", klog.KObj(cm))
+		return
+	}
+	t.set(topo.HostPorts())
+}
+
+func (t *poolTopology) updateNode(obj interface{}) {
+	if node, ok := obj.(*v1.Node); ok {
+		t.setLabel(node.Name, node.Labels[poolLabel])
+	}
+}
+
+func (t *poolTopology) set(hostPorts []topology.HostPort) {
+	t.Lock()
+	defer t.Unlock()
+	t.configured = hostPorts
+	t.rebuild()
+}
+
+func (t *poolTopology) setLabel(nodeName string, pool string) {
+	t.Lock()
+	defer t.Unlock()
+	if t.labeled[nodeName] == pool {
+		return
+	}
+	if pool == "" {
+		delete(t.labeled, nodeName)
+	} else {
+		t.labeled[nodeName] = pool
+	}
+	t.rebuild()
+}
+
+// Must be called with the lock held
+func (t *poolTopology) rebuild() {
+	nodePools := make(map[string]sets.Set[string])
+	poolNodes := make(map[string]sets.Set[string])
+	add := func(pool string, node string) {
+		if _, ok := nodePools[node]; !ok {
+			nodePools[node] = sets.New[string]()
+		}
+		if _, ok := poolNodes[pool]; !ok {
+			poolNodes[pool] = sets.New[string]()
+		}
+		nodePools[node].Insert(pool)
+		poolNodes[pool].Insert(node)
+	}
+	for _, hp := range t.configured {
+		add(hp.Pool, hp.Node)
+	}
+	for node, pool := range t.labeled {
+		add(pool, node)
+	}
+
+	t.nodePools = nodePools
+	t.poolNodes = poolNodes
+	klog.V(2).InfoS("Pool topology updated", "pools", len(poolNodes), "nodes", len(nodePools))
+}
+
+// Returns whether the GPUs attached to a node can be moved to the given nodes, through the pools their host ports are cabled to.
+// A GPU attached to a node cabled to several pools may belong to any of them, so it is only reachable if every pool of
+// its node is a pool of the given nodes. The GPUs of the given nodes themselves are always reachable, and every GPU is
+// reachable while the topology is unknown.
+func (t *poolTopology) reachableFrom(nodeNames ...string) func(string) bool {
+	if t == nil {
+		return func(string) bool { return true }
+	}
+	t.RLock()
+	defer t.RUnlock()
+	if len(t.poolNodes) == 0 {
+		return func(string) bool { return true }
+	}
+
+	targets := sets.New[string](nodeNames...)
+	pools := sets.New[string]()
+	for _, nodeName := range nodeNames {
+		pools = pools.Union(t.nodePools[nodeName])
+	}
+	nodePools := t.nodePools // replaced, never modified, by rebuild
+	return func(nodeName string) bool {
+		if targets.Has(nodeName) {
+			return true
+		}
+		return nodePools[nodeName].Len() > 0 && pools.IsSuperset(nodePools[nodeName])
+	}
+}
+
+// Returns the pools the host ports of the nodes are cabled to, nil while the topology is unknown
+func (t *poolTopology) poolsOf(nodeNames ...string) sets.Set[string] {
+	if t == nil {
+		return nil
+	}
+	t.RLock()
+	defer t.RUnlock()
+	if len(t.poolNodes) == 0 {
+		return nil
+	}
+	pools := sets.New[string]()
+	for _, nodeName := range nodeNames {
+		pools = pools.Union(t.nodePools[nodeName])
+	}
+	return pools
+}
+
+// Returns the GPUs the node can use, which are its own free GPUs and the free GPUs it can reach in its pools
+func (t *poolTopology) reachableFalcon(nodeName string, freeFalcon map[string]int64) int64 {
+	reachable := t.reachableFrom(nodeName)
+	total := int64(0)
+	for node, free := range freeFalcon {
+		if reachable(node) {
+			total += free
+		}
+	}
+	return total
+}
@@ -0,0 +1,58 @@
+package falconresources
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func newTestTopology() *poolTopology {
+	return &poolTopology{
+		name:      "falcon-topo",
+		labeled:   make(map[string]string),
+		nodePools: make(map[string]sets.Set[string]),
+		poolNodes: make(map[string]sets.Set[string]),
+	}
+}
+
+func topologyConfigMap(yaml string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "falcon-topo", Namespace: "kubecomp"},
+		Data:       map[string]string{topologyKey: yaml},
+	}
+}
+
+// The pools are read from the shared topology, and an invalid topology keeps the last valid one
+func TestPoolTopologyUpdate(t *testing.T) {
+	topo := newTestTopology()
+	topo.update(topologyConfigMap(`
+apiVersion: kubecomp/v1alpha1
+kind: Topology
+pools:
+- name: pool1
+  hostPorts:
+  - {port: "1", node: node1}
+  - {port: "2", node: node2}
+- name: pool2
+  hostPorts:
+  - {port: "3", node: node3}
+`))
+	topo.setLabel("node4", "pool2")
+
+	reachable := topo.reachableFrom("node1")
+	for node, want := range map[string]bool{"node1": true, "node2": true, "node3": false, "node4": false} {
+		if got := reachable(node); got != want {
+			t.Errorf("reachableFrom(node1)(%s) = %v, want %v", node, got, want)
+		}
+	}
+	if got := topo.reachableFalcon("node3", map[string]int64{"node1": 1, "node2": 2, "node3": 4, "node4": 8}); got != 12 {
+		t.Errorf("reachableFalcon(node3) = %d, want 12", got)
+	}
+
+	topo.update(topologyConfigMap("apiVersion: kubecomp/v1alpha1\nkind: Topology\npools: [{name: pool1, hostPorts: [{port: \"1\"}]}]\n"))
+	if !topo.reachableFrom("node1")("node2") {
+		t.Error("node2 is unreachable from node1 after an invalid topology, want the last valid topology kept")
+	}
+}
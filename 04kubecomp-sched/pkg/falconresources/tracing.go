@@ -0,0 +1,62 @@
+package falconresources
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+const tracerName string = "kubecomp-scheduler"
+
+var (
+	tracer = otel.Tracer(tracerName)
+
+	// The W3C trace context is written to the pod annotations, where reconfig-mgr reads it
+	tracePropagator = propagation.TraceContext{}
+
+	setupTracingOnce sync.Once
+	setupTracingErr  error
+)
+
+// Exports the spans of the plugin to the OTLP collector at endpoint (host:port), or drops them if endpoint is empty.
+// The spans are created in any case, their trace IDs are the request IDs in the logs.
+func setupTracing(endpoint string) error {
+	setupTracingOnce.Do(func() {
+		opts := []sdktrace.TracerProviderOption{
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+			sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(tracerName))),
+		}
+		if endpoint != "" {
+			exporter, err := otlptracegrpc.New(context.Background(),
+				otlptracegrpc.WithEndpoint(endpoint),
+				otlptracegrpc.WithInsecure(),
+			)
+			if err != nil {
+				setupTracingErr = err
+				return
+			}
+			opts = append(opts, sdktrace.WithBatcher(exporter))
+		}
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(opts...))
+	})
+	return setupTracingErr
+}
+
+// Writes the trace context of ctx to the annotations patched on the pod
+func injectTraceContext(ctx context.Context, annotations map[string]string) {
+	tracePropagator.Inject(ctx, propagation.MapCarrier(annotations))
+}
+
+// Returns the logger of ctx with the ID of the reconfiguration request, which is the ID of its trace.
+// reconfig-mgr and the pool log the same ID, so one reconfiguration can be followed across the components.
+func requestLogger(ctx context.Context) klog.Logger {
+	return klog.FromContext(ctx).WithValues("requestID", trace.SpanContextFromContext(ctx).TraceID().String())
+}
@@ -0,0 +1,102 @@
+package falconresources
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// unassignedPool caches the number of pool GPUs which are not attached to any host.
+// The count is published by reconfig-mgr under the "unassigned" key of a ConfigMap, and by pool as JSON under the
+// "unassigned_pools" key, since an unassigned GPU can only be attached to the host ports of its pool.
+type unassignedPool struct {
+	sync.RWMutex
+	name  string
+	count int64
+	pools map[string]int64 // nil if the counts by pool are not published
+}
+
+// Starts a namespaced informer on the ConfigMap and keeps the count up to date
+func newUnassignedPool(ctx context.Context, client kubernetes.Interface, namespace string, name string) *unassignedPool {
+	u := &unassignedPool{name: name}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			u.update(obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			u.update(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if cm, ok := obj.(*v1.ConfigMap); ok && cm.Name == u.name {
+				u.set(0, nil)
+			}
+		},
+	})
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	return u
+}
+
+func (u *unassignedPool) update(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok || cm.Name != u.name {
+		return
+	}
+	count, err := strconv.ParseInt(cm.Data["unassigned"], 10, 64)
+	if err != nil || count < 0 {
+		klog.InfoS("Invalid unassigned GPU count", "configMap", klog.KObj(cm), "count", cm.Data["unassigned"])
+		count = 0
+	}
+	var pools map[string]int64
+	if data, ok := cm.Data["unassigned_pools"]; ok {
+		if err := json.Unmarshal([]byte(data), &pools); err != nil {
+			klog.InfoS("Invalid unassigned GPU counts by pool", "configMap", klog.KObj(cm), "pools", data)
+			pools = map[string]int64{}
+		}
+	}
+	u.set(count, pools)
+}
+
+func (u *unassignedPool) set(count int64, pools map[string]int64) {
+	u.Lock()
+	defer u.Unlock()
+	if u.count != count {
+		klog.V(2).InfoS("Unassigned GPUs updated", "count", count, "pools", pools)
+	}
+	u.count = count
+	u.pools = pools
+}
+
+// Returns the GPUs which are not attached to any host and belong to one of the pools.
+// Every unassigned GPU is counted if pools is nil, which means the topology is unknown, or if reconfig-mgr does not
+// publish the counts by pool.
+func (u *unassignedPool) falcon(pools sets.Set[string]) int64 {
+	if u == nil {
+		return 0
+	}
+	u.RLock()
+	defer u.RUnlock()
+	if pools == nil || u.pools == nil {
+		return u.count
+	}
+	total := int64(0)
+	for pool := range pools {
+		total += u.pools[pool]
+	}
+	return total
+}
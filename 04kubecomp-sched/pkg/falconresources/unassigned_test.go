@@ -0,0 +1,55 @@
+package falconresources
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A node only counts the unassigned GPUs of its pools
+func TestUnassignedFalconByPool(t *testing.T) {
+	topo := newTestTopology()
+	topo.update(topologyConfigMap(`
+apiVersion: kubecomp/v1alpha1
+kind: Topology
+pools:
+- name: pool1
+  hostPorts:
+  - {port: "1", node: node1}
+- name: pool2
+  hostPorts:
+  - {port: "2", node: node2}
+`))
+	unassigned := &unassignedPool{name: "pool-unassigned"}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool-unassigned", Namespace: "kubecomp"},
+		Data:       map[string]string{"unassigned": "3", "unassigned_pools": `{"pool1":1,"pool2":2}`},
+	}
+	unassigned.update(cm)
+
+	tests := []struct {
+		name  string
+		nodes []string
+		want  int64
+	}{
+		{name: "node of pool1", nodes: []string{"node1"}, want: 1},
+		{name: "node of pool2", nodes: []string{"node2"}, want: 2},
+		{name: "nodes of both pools", nodes: []string{"node1", "node2"}, want: 3},
+		{name: "node of no pool", nodes: []string{"node3"}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unassigned.falcon(topo.poolsOf(tt.nodes...)); got != tt.want {
+				t.Errorf("falcon(poolsOf(%v)) = %d, want %d", tt.nodes, got, tt.want)
+			}
+		})
+	}
+
+	// Without the counts by pool, every unassigned GPU is counted as before
+	delete(cm.Data, "unassigned_pools")
+	unassigned.update(cm)
+	if got := unassigned.falcon(topo.poolsOf("node1")); got != 3 {
+		t.Errorf("falcon(poolsOf(node1)) = %d without the counts by pool, want 3", got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"reconfig-daemon/pkg/apis/v1alpha1"
+)
+
+// tokenHeader carries the bearer token of the callers going through the service proxy of the API server,
+// which authenticates them and strips their Authorization header
+const tokenHeader string = "X-Kubecomp-Authorization"
+
+// Returns the bearer token of the request, from its Authorization header or from the header of the proxied callers
+func bearerToken(r *http.Request) string {
+	for _, header := range []string{"Authorization", tokenHeader} {
+		if token, ok := strings.CutPrefix(r.Header.Get(header), "Bearer "); ok && token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// Returns the user of the bearer token of the request, authenticated by the API server with a TokenReview
+func (d *ReconfigDaemon) authenticate(ctx context.Context, r *http.Request) (authenticationv1.UserInfo, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return authenticationv1.UserInfo{}, fmt.Errorf("no bearer token")
+	}
+	review, err := d.clientset.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authenticationv1.UserInfo{}, fmt.Errorf("token review: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return authenticationv1.UserInfo{}, fmt.Errorf("invalid bearer token: %s", review.Status.Error)
+	}
+	return review.Status.User, nil
+}
+
+// Returns whether the user may do what the attributes describe, checked by the API server with a SubjectAccessReview
+func (d *ReconfigDaemon) allowed(ctx context.Context, user authenticationv1.UserInfo, spec authorizationv1.SubjectAccessReviewSpec) (bool, error) {
+	spec.User = user.Username
+	spec.UID = user.UID
+	spec.Groups = user.Groups
+	spec.Extra = make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for key, value := range user.Extra {
+		spec.Extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review, err := d.clientset.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{Spec: spec}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("subject access review: %v", err)
+	}
+	return review.Status.Allowed, nil
+}
+
+// Authenticates the caller and checks it may do what the attributes describe, answers 401 or 403 and returns false
+// otherwise
+func (d *ReconfigDaemon) authorize(w http.ResponseWriter, r *http.Request, spec authorizationv1.SubjectAccessReviewSpec) (authenticationv1.UserInfo, bool) {
+	user, err := d.authenticate(r.Context(), r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
+		return user, false
+	}
+	allowed, err := d.allowed(r.Context(), user, spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return user, false
+	}
+	if !allowed {
+		klog.V(2).InfoS("Refused the request", "path", r.URL.Path, "user", user.Username)
+		http.Error(w, fmt.Sprintf("Forbidden: user %q may not %s", user.Username, describeAccess(spec)), http.StatusForbidden)
+		return user, false
+	}
+	return user, true
+}
+
+func describeAccess(spec authorizationv1.SubjectAccessReviewSpec) string {
+	if attrs := spec.NonResourceAttributes; attrs != nil {
+		return fmt.Sprintf("%s %s", attrs.Verb, attrs.Path)
+	}
+	attrs := spec.ResourceAttributes
+	resource := attrs.Resource
+	if attrs.Group != "" {
+		resource += "." + attrs.Group
+	}
+	return fmt.Sprintf("%s %s %s", attrs.Verb, resource, attrs.Name)
+}
+
+// Moving a device is updating its PoolDevice object, which RBAC grants like any other resource
+func moveAccess(devID string) authorizationv1.SubjectAccessReviewSpec {
+	return authorizationv1.SubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{
+		Verb: "update", Group: v1alpha1.GroupName, Resource: "pooldevices", Name: devID,
+	}}
+}
+
+// Moving a reserved device takes the right to update the ConfigMap of the reservations, which only the DRA driver has
+func (d *ReconfigDaemon) reservationAccess() authorizationv1.SubjectAccessReviewSpec {
+	return authorizationv1.SubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{
+		Verb: "update", Resource: "configmaps", Namespace: d.unassignedNamespace, Name: d.reservedConfigMap,
+	}}
+}
+
+// Changing the log level is authorized like the /debug/flags/v endpoint of the Kubernetes components
+func (d *ReconfigDaemon) requireLogLevelAccess(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spec := authorizationv1.SubjectAccessReviewSpec{NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+			Verb: "put", Path: r.URL.Path,
+		}}
+		if _, ok := d.authorize(w, r, spec); ok {
+			handler(w, r)
+		}
+	}
+}
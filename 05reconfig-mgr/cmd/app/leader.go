@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	leaseDuration time.Duration = 15 * time.Second
+	renewDeadline time.Duration = 10 * time.Second
+	retryPeriod   time.Duration = 2 * time.Second
+
+	leaderLabel string = "kubecomp.io/leader" // set on the pod of the leader, which the Service selects
+)
+
+// leaderConfig is read from the leader_* keys of the config file and the downward API
+type leaderConfig struct {
+	enabled   bool
+	namespace string // namespace and name of the Lease
+	name      string
+	identity  string // name of this replica
+}
+
+func newLeaderConfig(config map[string]string) leaderConfig {
+	cfg := leaderConfig{
+		enabled:   config["leader_elect"] == "true",
+		namespace: os.Getenv("POD_NAMESPACE"),
+		name:      config["lease_name"],
+		identity:  os.Getenv("POD_NAME"),
+	}
+	if cfg.namespace == "" {
+		cfg.namespace = "kubecomp"
+	}
+	if cfg.name == "" {
+		cfg.name = "reconfig-mgr"
+	}
+	if cfg.identity == "" {
+		cfg.identity, _ = os.Hostname()
+	}
+	return cfg
+}
+
+// Runs the controllers, only while holding the Lease if leader election is enabled.
+// A replica which loses the Lease exits, so that it restarts as a standby with no state left.
+func (d *ReconfigDaemon) runWithLeaderElection(cfg leaderConfig, run func(stopCh <-chan struct{})) {
+	if !cfg.enabled {
+		if err := d.setLeaderLabel(context.Background(), cfg, true); err != nil {
+			fatal(err, "Failed to set the leader label")
+		}
+		run(make(chan struct{}))
+		return
+	}
+	// A restarted leader keeps the labels of its pod, so it removes its label before standing by
+	if err := d.setLeaderLabel(context.Background(), cfg, false); err != nil {
+		klog.ErrorS(err, "Failed to remove the leader label", "pod", klog.KRef(cfg.namespace, cfg.identity))
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: cfg.namespace,
+			Name:      cfg.name,
+		},
+		Client: d.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: cfg.identity,
+		},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				token, err := d.fencingToken(ctx, cfg)
+				if err != nil {
+					fatal(err, "Failed to read the fencing token")
+				}
+				d.devIF.SetFencingToken(token)
+				if err := d.setLeaderLabel(ctx, cfg, true); err != nil {
+					fatal(err, "Failed to set the leader label")
+				}
+				klog.InfoS("Leading, rebuilding the state from the cluster and the pool", "identity", cfg.identity, "fencingToken", token)
+				run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				fatal(nil, "Lost the leadership, exiting", "identity", cfg.identity)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != cfg.identity {
+					klog.InfoS("Standing by", "leader", identity, "identity", cfg.identity)
+				}
+			},
+		},
+		Name: cfg.name,
+	})
+	if err != nil {
+		fatal(err, "Failed to create leader elector")
+	}
+	d.elector = elector
+
+	klog.InfoS("Waiting for the Lease", "identity", cfg.identity, "lease", klog.KRef(cfg.namespace, cfg.name))
+	elector.Run(context.Background())
+}
+
+// Returns the number of times the Lease changed hands, which increases with every new leader
+func (d *ReconfigDaemon) fencingToken(ctx context.Context, cfg leaderConfig) (int64, error) {
+	lease, err := d.clientset.CoordinationV1().Leases(cfg.namespace).Get(ctx, cfg.name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != cfg.identity {
+		return 0, fmt.Errorf("the Lease is held by another replica")
+	}
+	if lease.Spec.LeaseTransitions == nil {
+		return 0, nil
+	}
+	return int64(*lease.Spec.LeaseTransitions), nil
+}
+
+// Sets or removes the leader label on the pod of this replica, so that the Service only reaches the leader
+func (d *ReconfigDaemon) setLeaderLabel(ctx context.Context, cfg leaderConfig, leading bool) error {
+	var value interface{} // null removes the label
+	if leading {
+		value = "true"
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{leaderLabel: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = d.clientset.CoreV1().Pods(cfg.namespace).Patch(ctx, cfg.identity, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// Returns false if this replica is not the leader anymore, and must not move any device
+func (d *ReconfigDaemon) isLeader() bool {
+	return d.elector == nil || d.elector.IsLeader()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+// Registers the klog flags and sets the verbosity from the log_level key of the config file
+func setupLogging(level string) error {
+	klog.InitFlags(nil)
+	if level == "" {
+		return nil
+	}
+	return flag.Set("v", level)
+}
+
+// Handles the PUT /debug/flags/v request, which changes the verbosity at runtime like the Kubernetes components
+func putLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level := strings.TrimSpace(string(body))
+	if err := flag.Set("v", level); err != nil {
+		http.Error(w, fmt.Sprintf("invalid log level %q: %v", level, err), http.StatusBadRequest)
+		return
+	}
+	klog.InfoS("Log level changed", "level", level)
+	fmt.Fprintf(w, "successfully set klog.logging.verbosity to %s\n", level)
+}
+
+// Returns the ID of the reconfiguration request, which is the ID of its trace.
+// The scheduler and the pool log the same ID, so one reconfiguration can be followed across the components.
+func requestID(ctx context.Context) string {
+	return trace.SpanContextFromContext(ctx).TraceID().String()
+}
+
+// Logs the error and exits, the replacement of log.Fatalf
+func fatal(err error, msg string, keysAndValues ...interface{}) {
+	klog.ErrorS(err, msg, keysAndValues...)
+	klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+}
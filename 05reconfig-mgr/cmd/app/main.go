@@ -3,99 +3,254 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
-	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
 
+	"kubecomp/topology"
 	"reconfig-daemon/pkg/inter"
+	"reconfig-daemon/pkg/strategy"
 )
 
-type PodInfo struct {
-	name      string
-	namespace string
-	gids      []string // Global ids for devices
-}
+const maxDeviceBackoff time.Duration = 30 * time.Second // longest wait between two reads of the pool at startup
 
 type ReconfigDaemon struct {
-	deviceAlloc     map[string]string // DevID to HostPort mapping
-	config          *rest.Config
-	clientset       *kubernetes.Clientset
-	schedulePods    sets.Set[types.UID]
-	ignorePods      sets.Set[types.UID]
-	schedulePodInfo map[types.UID]PodInfo
-	nodeNameToPort  map[string]string // nodeName to HostPort mapping
-	devIF           *inter.FalconInterface
+	devLock          sync.RWMutex
+	deviceAlloc      map[string]string    // DevID to HostPort mapping
+	lastMoved        map[string]time.Time // DevID to the time it was last assigned
+	lastPools        map[string]string    // DevID to the pool of the host port it was last attached to
+	config           *rest.Config
+	clientset        kubernetes.Interface
+	factory          informers.SharedInformerFactory
+	podLister        corelisters.PodLister
+	nodeLister       corelisters.NodeLister
+	pods             *podIndex                       // GPU pods and the devices they own
+	reserved         *reservedDevices                // devices reserved by the DRA driver
+	requests         workqueue.RateLimitingInterface // keys of the target nodes requesting reconfiguration
+	pending          *requestTable                   // requests waiting in the queue
+	drains           workqueue.RateLimitingInterface // names of the nodes entering or leaving maintenance
+	failures         workqueue.RateLimitingInterface // names of the nodes not ready or fenced
+	inflight         *inflightSet                    // devices and ports being reconfigured
+	topoLock         sync.RWMutex
+	topo             *topology.Topology // reloaded when its ConfigMap changes
+	labeledPorts     map[string]string  // nodeName to the HostPort labeled by the device plugin
+	nodeNameToPort   map[string]string  // nodeName to HostPort mapping, replaced as a whole on reload
+	portSwitches     map[string]string  // HostPort to the switch it is behind, replaced as a whole on reload
+	devIF            inter.DeviceInterface
+	strategy         strategy.SelectionStrategy // chooses the devices to move
+	recorder         record.EventRecorder       // reports the outcomes on pods
+	dryRun           bool                       // computes the plans without moving any GPU
+	dryRuns          *dryRunRecords             // recent plans computed in dry-run mode
+	activity         *activityTracker           // when the last request was handled, for the rebalancer
+	maintenanceTaint string                     // taint key putting a node in maintenance, like cordoning it
+	notReadyGrace    time.Duration              // time a node stays not ready before its devices are force-detached
+
+	elector *leaderelection.LeaderElector // nil if leader election is disabled
+	serving atomic.Bool                   // the leader built its state and serves the API
+
+	unassignedLock      sync.Mutex
+	unassignedPublished map[string]string // unassigned GPUs last published, nil if never
+	unassignedNamespace string            // namespace and name of the ConfigMap publishing the unassigned GPUs
+	unassignedConfigMap string
+	reservedConfigMap   string // ConfigMap of the devices reserved by the DRA driver, in the same namespace
 }
 
 func newReconfigDaemon(getResourceEndpoint string, reconfigEndpoint string) *ReconfigDaemon {
 	d := &ReconfigDaemon{
-		deviceAlloc:     make(map[string]string),
-		schedulePods:    sets.New[types.UID](),
-		ignorePods:      sets.New[types.UID](),
-		schedulePodInfo: make(map[types.UID]PodInfo),
-		nodeNameToPort:  make(map[string]string),
-		devIF:           inter.NewDevInterface(getResourceEndpoint, reconfigEndpoint),
+		deviceAlloc:    make(map[string]string),
+		lastMoved:      make(map[string]time.Time),
+		lastPools:      make(map[string]string),
+		labeledPorts:   make(map[string]string),
+		nodeNameToPort: make(map[string]string),
+		portSwitches:   make(map[string]string),
+		devIF:          inter.NewDevInterface(getResourceEndpoint, reconfigEndpoint),
+		requests:       workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(requestRetryBaseDelay, requestRetryMaxDelay)),
+		pending:        newRequestTable(),
+		drains:         workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		failures:       workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		inflight:       newInflightSet(),
+		dryRuns:        &dryRunRecords{},
+		activity:       &activityTracker{last: time.Now()},
+		reserved:       &reservedDevices{devices: sets.New[string]()},
 	}
 
 	var err error
 	d.config, err = rest.InClusterConfig()
 	if err != nil {
-		log.Fatalf("Failed to get in-cluster config: %v", err)
+		fatal(err, "Failed to get in-cluster config")
 	}
 
 	d.clientset, err = kubernetes.NewForConfig(d.config)
 	if err != nil {
-		log.Fatalf("Failed to create clientset: %v", err)
+		fatal(err, "Failed to create clientset")
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: d.clientset.CoreV1().Events("")})
+	d.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "reconfig-mgr"})
+
+	d.factory = informers.NewSharedInformerFactory(d.clientset, 0)
+	podInformer := d.factory.Core().V1().Pods()
+	d.podLister = podInformer.Lister()
+	d.pods = newPodIndex(d.getGID)
+	if _, err := podInformer.Informer().AddEventHandler(d.pods.eventHandler()); err != nil {
+		fatal(err, "Failed to add pod event handler")
+	}
+	if _, err := podInformer.Informer().AddEventHandler(d.reconfigRequestHandler()); err != nil {
+		fatal(err, "Failed to add reconfiguration request handler")
+	}
+	nodeInformer := d.factory.Core().V1().Nodes()
+	d.nodeLister = nodeInformer.Lister()
+	if _, err := nodeInformer.Informer().AddEventHandler(d.nodeTopologyEventHandler()); err != nil {
+		fatal(err, "Failed to add node topology event handler")
+	}
+	if _, err := nodeInformer.Informer().AddEventHandler(d.nodeEventHandler()); err != nil {
+		fatal(err, "Failed to add node event handler")
+	}
+	if _, err := nodeInformer.Informer().AddEventHandler(d.nodeFailureEventHandler()); err != nil {
+		fatal(err, "Failed to add node failure event handler")
 	}
 
 	return d
 }
 
 func (d *ReconfigDaemon) updateDevice() error {
+	start := time.Now()
 	devices, err := d.devIF.GetAllResource()
+	observePoolAPI("get_resources", start, err)
 	if err != nil {
 		return err
 	}
+	deviceAlloc := make(map[string]string, len(devices))
 	for _, dp := range devices {
-		d.deviceAlloc[dp.DevID] = dp.HostPort
+		deviceAlloc[dp.DevID] = dp.HostPort
+	}
+
+	portPools := d.portPools()
+	d.devLock.Lock()
+	d.deviceAlloc = deviceAlloc
+	for dev, port := range deviceAlloc {
+		if port != "" {
+			d.lastPools[dev] = portPools[port]
+		}
+	}
+	d.devLock.Unlock()
+
+	// The unassigned devices reserved by the DRA driver are about to be attached to the nodes of its claims
+	unassigned := 0
+	poolUnassigned := make(map[string]int)
+	reserved := d.reserved.get()
+	devicePools := d.devicePools(portPools)
+	for dev, port := range deviceAlloc {
+		if port == "" && !reserved.Has(dev) {
+			unassigned++
+			if pool, ok := devicePools[dev]; ok {
+				poolUnassigned[pool]++
+			} else {
+				klog.V(2).InfoS("Unassigned device of an unknown pool, list it under the devices of its pool in the topology", "devid", dev)
+			}
+		}
 	}
 
+	d.publishUnassigned(unassigned, poolUnassigned)
+	d.updatePoolMetrics(deviceAlloc, d.usedDevices())
 	return nil
 }
 
-func (d *ReconfigDaemon) assign(portGID string, devGID string) error {
-	log.Printf("Assign: devGID %s to portGID %s\n", devGID, portGID)
-	ok, err := d.devIF.Assign(portGID, devGID)
+// Refreshes the devices until the pool answers, with a backoff, so that the workers never start from an empty device
+// map. Returns false if stopped first.
+func (d *ReconfigDaemon) waitForDevices(stopCh <-chan struct{}) bool {
+	backoff := time.Second
+	for {
+		err := d.updateDevice()
+		if err == nil {
+			return true
+		}
+		klog.ErrorS(err, "Failed to read the devices from the pool, retrying", "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-stopCh:
+			return false
+		}
+		if backoff < maxDeviceBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// Returns the last known DevID to HostPort mapping, which must not be modified
+func (d *ReconfigDaemon) getDeviceAlloc() map[string]string {
+	d.devLock.RLock()
+	defer d.devLock.RUnlock()
+	return d.deviceAlloc
+}
+
+// Returns the last time each device was assigned
+func (d *ReconfigDaemon) getLastMoved() map[string]time.Time {
+	d.devLock.RLock()
+	defer d.devLock.RUnlock()
+	lastMoved := make(map[string]time.Time, len(d.lastMoved))
+	for dev, t := range d.lastMoved {
+		lastMoved[dev] = t
+	}
+	return lastMoved
+}
+
+func (d *ReconfigDaemon) assign(ctx context.Context, portGID string, devGID string) error {
+	if !d.isLeader() {
+		return fmt.Errorf("not the leader, device %s is not assigned", devGID)
+	}
+	klog.FromContext(ctx).Info("Assigning device", "devid", devGID, "hostport", portGID)
+	start := time.Now()
+	ok, err := d.devIF.Assign(ctx, portGID, devGID)
+	observePoolAPI("assign", start, err)
 	if err != nil || !ok {
 		return err
 	}
+
+	d.devLock.Lock()
+	d.lastMoved[devGID] = time.Now()
+	d.devLock.Unlock()
 	return nil
 }
 
-func (d *ReconfigDaemon) unassign(devGID string) error {
-	log.Printf("Unassign devGID %s\n", devGID)
-	ok, err := d.devIF.Unassign(devGID)
+func (d *ReconfigDaemon) unassign(ctx context.Context, devGID string) error {
+	if !d.isLeader() {
+		return fmt.Errorf("not the leader, device %s is not unassigned", devGID)
+	}
+	klog.FromContext(ctx).Info("Unassigning device", "devid", devGID)
+	start := time.Now()
+	ok, err := d.devIF.Unassign(ctx, devGID)
+	observePoolAPI("unassign", start, err)
 	if err != nil || !ok {
 		return err
 	}
 	return nil
 }
 
-func (d *ReconfigDaemon) getGID(name string, namespace string) []string {
+// Returns the devices of the pod from its DISAG_DEVICES variable, "-1" if it has none.
+// An error means the devices are unknown, not that the pod has none.
+func (d *ReconfigDaemon) getGID(name string, namespace string) ([]string, error) {
 	restClient := d.clientset.CoreV1().RESTClient()
 	cmd := []string{
 		"sh",
@@ -120,8 +275,7 @@ func (d *ReconfigDaemon) getGID(name string, namespace string) []string {
 
 	exec, err := remotecommand.NewSPDYExecutor(d.config, "POST", req.URL())
 	if err != nil {
-		log.Printf("Failed to create executor: %v", err)
-		return []string{"-1"}
+		return nil, fmt.Errorf("failed to create executor: %v", err)
 	}
 
 	var stdout bytes.Buffer
@@ -131,258 +285,172 @@ func (d *ReconfigDaemon) getGID(name string, namespace string) []string {
 		Stderr: nil,     // stderr,
 	})
 	if err != nil {
-		log.Printf("Failed to stream command output: %v", err)
-		return []string{"-1"}
+		return nil, fmt.Errorf("failed to stream command output: %v", err)
 	}
 
-	envVar := stdout.String()
+	envVar := strings.TrimSuffix(stdout.String(), "\n")
 	if envVar == "" {
 		// no gpu
-		return []string{"-1"}
+		return []string{"-1"}, nil
 	}
-	envVar = envVar[:len(envVar)-1] // remove \n
 	gids := strings.Split(envVar, ",")
-	return gids
+	return gids, nil
 }
 
-func (d *ReconfigDaemon) reconfig(nodeName string, demand int) bool {
-	log.Printf("Reconfiguring node: %s with demand: %d", nodeName, demand)
-
-	if err := d.updateDevice(); err != nil {
-		log.Printf("Failed to update devices: %v", err)
-		return false
+func main() {
+	var configPath string = "/etc/kubernetes/reconfig-mgr-config.yaml"
+	buf, err := os.ReadFile(configPath)
+	if err != nil {
+		fatal(err, "Failed to read config file", "path", configPath)
 	}
 
-	usedGPUs := sets.Set[string]{}
-	for po := range d.schedulePods {
-		usedGPUs.Insert(d.schedulePodInfo[po].gids...)
+	var config map[string]string
+	if err := yaml.Unmarshal(buf, &config); err != nil {
+		fatal(err, "Failed to unmarshal YAML", "path", configPath)
 	}
-
-	var optionGPUs []struct {
-		devGID   string
-		hostPort string
-		score    int
+	if err := setupLogging(config["log_level"]); err != nil {
+		klog.ErrorS(err, "Invalid log level, using the default", "level", config["log_level"])
 	}
+	defer klog.Flush()
 
-	for dev, nodePort := range d.deviceAlloc {
-		// optionGPUs are GPUs that are not used and not connected to the target node
-		if !usedGPUs.Has(dev) && nodePort != d.nodeNameToPort[nodeName] {
-			optionGPUs = append(optionGPUs, struct {
-				devGID   string
-				hostPort string
-				score    int
-			}{dev, nodePort, 0})
-		}
+	// The topology is shared with the device plugin, the legacy keys of the config file are still read without it
+	topo, err := topology.Load(topology.DefaultPath)
+	if errors.Is(err, os.ErrNotExist) {
+		klog.InfoS("Topology file not found, reading the deprecated node_names and host_ports keys", "path", topology.DefaultPath)
+		topo, err = topology.FromLegacy(config["get_rec_endpoint"], config["reconfig_endpoint"],
+			config["node_names"], "", config["host_ports"], config["port_switches"])
 	}
-
-	// Calculates score for each GPU option
-	gpuCounts := make(map[string]int)
-	for _, gpuOption := range optionGPUs {
-		gpuCounts[gpuOption.hostPort]++
+	if err != nil {
+		klog.ErrorS(err, "Invalid topology, using the default endpoints and the host port labels until it is fixed")
+		topo = topology.Default()
 	}
-	for i := range optionGPUs {
-		optionGPUs[i].score = gpuCounts[optionGPUs[i].hostPort]
+	workers, err := strconv.Atoi(config["workers"])
+	if err != nil || workers < 1 {
+		workers = 4
 	}
 
-	sort.Slice(optionGPUs, func(i, j int) bool {
-		return optionGPUs[i].score < optionGPUs[j].score
-	})
-
-	// Performs reconfiguration
-	for _, dev := range optionGPUs {
-		if demand <= 0 {
-			break
-		}
-
-		if err := d.unassign(dev.devGID); err != nil {
-			log.Printf("Unassign failed for device %s: %v", dev.devGID, err)
-			continue
-		}
+	d := newReconfigDaemon(topo.Endpoints.Resources, topo.Endpoints.Allocation)
+	klog.InfoS("Reconfig-Mgr starts")
 
-		if err := d.assign(d.nodeNameToPort[nodeName], dev.devGID); err != nil {
-			log.Printf("Assign failed for device %s: %v", dev.devGID, err)
-			continue
-		}
-
-		demand--
-	}
-	return demand == 0
-}
+	d.setTopology(topo)
+	d.watchTopology()
 
-func (d *ReconfigDaemon) podUseFalcon(name string, namespace string) bool {
-	po, err := d.clientset.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	// Invalid optional values fall back to their defaults
+	d.strategy, err = strategy.New(config["selection_strategy"])
 	if err != nil {
-		log.Printf("Failed to get pod: %v", err)
-		return false
+		klog.ErrorS(err, "Invalid selection strategy, using the default", "available", strategy.Names())
+		d.strategy, _ = strategy.New("")
 	}
-	return po.ObjectMeta.Annotations["use_falcon"] == "true"
-}
+	klog.InfoS("Using selection strategy", "strategy", d.strategy.Name())
 
-func (d *ReconfigDaemon) podIsScheduled(name string, namespace string) bool {
-	po, err := d.clientset.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		log.Printf("Failed to get pod: %v", err)
+	d.dryRun = config["dry_run"] == "true"
+	if d.dryRun {
+		klog.InfoS("Dry run, GPUs are never moved")
 	}
-	for _, cond := range po.Status.Conditions {
-		if cond.Type == "PodScheduled" {
-			return cond.Status == "True"
-		}
+	listenAddress := config["listen_address"]
+	if listenAddress == "" {
+		listenAddress = ":8080"
 	}
-	return false
-}
 
-func (d *ReconfigDaemon) getPodAnnotation(name string, namespace string, annotation string) string {
-	po, err := d.clientset.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		log.Printf("Failed to get pod: %v", err)
-		return ""
+	// The rebalancer is disabled unless an interval is given
+	rebalance := rebalancerConfig{layout: config["rebalance_layout"]}
+	if seconds, err := strconv.Atoi(config["rebalance_interval"]); err == nil && seconds > 0 {
+		rebalance.interval = time.Duration(seconds) * time.Second
 	}
-	return po.ObjectMeta.Annotations[annotation]
-}
-
-func (d *ReconfigDaemon) watchReconfigEvent(eventChan <-chan watch.Event) error {
-	for {
-		select {
-		case event := <-eventChan:
-			ev, ok := event.Object.(*v1.Event)
-			if !ok {
-				log.Printf("Unexpected event object type: %T\n", event.Object)
-				continue
-			}
-
-			name := ev.InvolvedObject.Name
-			namespace := ev.InvolvedObject.Namespace
-
-			curPod, err := d.clientset.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-
-			if err != nil || curPod.Status.Phase != "Pending" {
-				// skip if the pod does not exist or is already scheduled
-				continue
-			}
-
-			log.Printf("Reconfig event detected for pod: %s/%s, reason: %s", name, namespace, ev.Reason)
-
-			if err := d.waitReadyToReconfig(name, namespace); err != nil {
-				return fmt.Errorf("error waiting the cluster ready: %v", err)
-			}
-			nodeName := d.getPodAnnotation(name, namespace, "dst_node")
-			demand := d.getPodAnnotation(name, namespace, "gpu_demand")
-			demandCnt, err := strconv.Atoi(demand)
-			if err != nil {
-				log.Printf("Invalid GPU demand for pod %s/%s: %v", namespace, name, err)
-				continue
-			}
-
-			if !d.reconfig(nodeName, demandCnt) {
-				log.Printf("Failed to satisfy GPU demand for pod %s/%s", namespace, name)
-			}
-		}
+	rebalance.idle = rebalance.interval
+	if seconds, err := strconv.Atoi(config["rebalance_idle"]); err == nil && seconds >= 0 {
+		rebalance.idle = time.Duration(seconds) * time.Second
 	}
-}
-
-func (d *ReconfigDaemon) waitReadyToReconfig(name string, namespace string) error {
-	ready := false
-	d.schedulePods = sets.Set[types.UID]{}
-	for !ready {
-		ready = true
-		allPods, err := d.clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to list pods: %v", err)
-		}
-		for _, po := range allPods.Items {
-			uid := po.ObjectMeta.UID
-			if d.ignorePods.Has(uid) {
-				continue
-			}
-			if (po.ObjectMeta.Name == name) && (po.ObjectMeta.Namespace == namespace) {
-				// current pod
-				continue
-			}
-			if !d.podIsScheduled(po.ObjectMeta.Name, po.ObjectMeta.Namespace) {
-				continue
-			}
-			if !d.podUseFalcon(po.ObjectMeta.Name, po.ObjectMeta.Namespace) {
-				d.ignorePods.Insert(uid)
-				continue
-			}
-			if (po.Status.Phase == "Succeeded") || (po.Status.Phase == "Failed") {
-				d.ignorePods.Insert(uid)
-				delete(d.schedulePodInfo, uid)
-				continue
-			}
-			d.schedulePods.Insert(uid)
-
-			if po.Status.Phase == "Running" && len(d.schedulePodInfo[uid].gids) == 0 {
-				info := PodInfo{
-					name:      po.ObjectMeta.Name,
-					namespace: po.ObjectMeta.Namespace,
-					gids:      d.getGID(po.ObjectMeta.Name, po.ObjectMeta.Namespace),
-				}
-				d.schedulePodInfo[uid] = info
-			} else if po.Status.Phase == "Pending" {
-				log.Printf("Not ready due to Pod %s\n", po.ObjectMeta.Name)
-				ready = false
-			}
-		}
+	rebalance.budget, err = strconv.Atoi(config["rebalance_budget"])
+	if err != nil || rebalance.budget < 1 {
+		rebalance.budget = 2
+	}
+	if rebalance.layout == "" {
+		rebalance.layout = layoutPacked
+	}
+	if rebalance.layout != layoutPacked && rebalance.layout != layoutSpread {
+		klog.ErrorS(nil, "Invalid rebalance layout, using the default", "layout", rebalance.layout, "available", []string{layoutPacked, layoutSpread}, "default", layoutPacked)
+		rebalance.layout = layoutPacked
 	}
-	return nil
-}
 
-func main() {
-	var configPath string = "/etc/kubernetes/reconfig-mgr-config.yaml"
-	buf, err := os.ReadFile(configPath)
+	// The reclaimer is disabled unless an idle time is given
+	var reclaim reclaimerConfig
+	if seconds, err := strconv.Atoi(config["reclaim_idle"]); err == nil && seconds > 0 {
+		reclaim.idle = time.Duration(seconds) * time.Second
+	}
+	reclaim.defaultWarm, reclaim.warm, err = parseWarmCapacity(config["warm_capacity"])
 	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
+		klog.ErrorS(err, "Invalid warm capacity, keeping no warm GPU")
+		reclaim.defaultWarm, reclaim.warm = 0, map[string]int{}
 	}
-
-	var config map[string]string
-	if err := yaml.Unmarshal(buf, &config); err != nil {
-		log.Fatalf("Failed to unmarshal YAML: %v", err)
+	d.unassignedNamespace = config["unassigned_namespace"]
+	if d.unassignedNamespace == "" {
+		d.unassignedNamespace = "kubecomp"
 	}
-
-	// Extracts configuration values difined in deploy/helm/falcon/values.yaml
-	nodeNamesList := strings.Split(config["node_names"], ",")
-	hostPortList := strings.Split(config["host_ports"], ",")
-	getResourceEndpoint := config["get_rec_endpoint"]
-	reconfigEndpoint := config["reconfig_endpoint"]
-
-	d := newReconfigDaemon(getResourceEndpoint, reconfigEndpoint)
-	log.Println("Reconfig-Mgr starts.")
-
-	if len(nodeNamesList) != len(hostPortList) {
-		log.Fatalf("Host port or Node name is missing")
+	d.maintenanceTaint = config["maintenance_taint"]
+	if d.maintenanceTaint == "" {
+		d.maintenanceTaint = "falcon.com/maintenance"
 	}
-	for i, name := range nodeNamesList {
-		d.nodeNameToPort[name] = hostPortList[i]
+	d.notReadyGrace = 300 * time.Second
+	if seconds, err := strconv.Atoi(config["not_ready_grace"]); err == nil && seconds > 0 {
+		d.notReadyGrace = time.Duration(seconds) * time.Second
 	}
-
-	d.updateDevice()
-
-	opts := metav1.ListOptions{
-		FieldSelector: "involvedObject.kind=Pod",
-		Watch:         true,
+	// The pool is mirrored into the ComposablePool, PoolDevice and HostPort objects unless the interval is 0
+	var poolSyncInterval time.Duration
+	if seconds, err := strconv.Atoi(config["pool_sync_interval"]); err == nil && seconds > 0 {
+		poolSyncInterval = time.Duration(seconds) * time.Second
+	}
+	d.unassignedConfigMap = config["unassigned_configmap"]
+	if d.unassignedConfigMap == "" {
+		d.unassignedConfigMap = "pool-unassigned"
 	}
-	watcher, err := d.clientset.CoreV1().Events("").Watch(context.TODO(), opts)
+	d.reservedConfigMap = config["dra_reserved_configmap"]
+	if d.reservedConfigMap == "" {
+		d.reservedConfigMap = "pool-dra-reserved"
+	}
+
+	// Tracing is disabled unless a collector is given
+	shutdownTracing, err := setupTracing(config["otlp_endpoint"])
 	if err != nil {
-		panic(err.Error())
+		fatal(err, "Failed to set up tracing")
+	}
+	defer shutdownTracing(context.Background())
+	if config["otlp_endpoint"] != "" {
+		klog.InfoS("Exporting traces", "endpoint", config["otlp_endpoint"])
 	}
 
-	reconfigChan := make(chan watch.Event)
+	// Every replica serves its health and metrics, the API is only served by the leader once its state is built
+	go d.startServer(listenAddress)
 
-	go func() {
-		err := d.watchReconfigEvent(reconfigChan)
-		if err != nil {
-			log.Printf("watchReconfigEvent err: %v\n", err)
+	// The state is built from the cluster and the pool when this replica starts leading
+	d.runWithLeaderElection(newLeaderConfig(config), func(stopCh <-chan struct{}) {
+		d.watchReserved(d.unassignedNamespace, d.reservedConfigMap, stopCh)
+		if !d.waitForDevices(stopCh) {
+			return
 		}
-	}()
 
-	for {
-		select {
-		case event := <-watcher.ResultChan():
-			ev, _ := event.Object.(*v1.Event)
-			if ev.Reason == "Reconfig" {
-				reconfigChan <- event
-			}
+		// The requests are read from the annotations of the pods, the pending requests made before this replica led
+		// are enqueued when the pods are listed
+		d.factory.Start(stopCh)
+		d.factory.WaitForCacheSync(stopCh)
+		d.serving.Store(true)
+
+		if rebalance.interval > 0 {
+			go d.runRebalancer(rebalance, stopCh)
 		}
-	}
+		if reclaim.idle > 0 {
+			go d.runReclaimer(reclaim, stopCh)
+		}
+		if poolSyncInterval > 0 {
+			go d.runPoolSync(poolSyncInterval, stopCh)
+		}
+		go d.runDrainWorker()
+		go d.runNodeFailureWorker()
+
+		klog.InfoS("Starting reconfiguration workers", "workers", workers)
+		for i := 0; i < workers; i++ {
+			go d.runReconfigWorker()
+		}
+		<-stopCh
+	})
 }
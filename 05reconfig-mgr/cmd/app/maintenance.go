@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	drainRecheckPeriod time.Duration = 10 * time.Second // time between two drains of a node whose pods still own GPUs
+
+	// Condition on the nodes in maintenance, true once no pool GPU is attached to them
+	conditionGPUsDrained v1.NodeConditionType = "FalconGPUsDrained"
+
+	reasonGPUDrainStarted  string = "GPUDrainStarted"
+	reasonGPUDetached      string = "GPUDetached"
+	reasonGPUsDrained      string = "GPUsDrained"
+	reasonGPUsInUse        string = "GPUsInUse"
+	reasonNotInMaintenance string = "NotInMaintenance"
+)
+
+// Returns true if the node is cordoned or carries the maintenance taint
+func (d *ReconfigDaemon) inMaintenance(node *v1.Node) bool {
+	if node.Spec.Unschedulable {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == d.maintenanceTaint {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the event handlers which queue the nodes entering or leaving maintenance
+func (d *ReconfigDaemon) nodeEventHandler() cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		node, ok := obj.(*v1.Node)
+		if !ok {
+			return
+		}
+		if _, ok := d.nodePorts()[node.Name]; ok {
+			d.drains.Add(node.Name)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old, obj interface{}) {
+			oldNode, ok1 := old.(*v1.Node)
+			node, ok2 := obj.(*v1.Node)
+			if ok1 && ok2 && !d.inMaintenance(oldNode) && !d.inMaintenance(node) {
+				return
+			}
+			enqueue(obj)
+		},
+	}
+}
+
+// Drains one node at a time, the pool devices are shared by all nodes
+func (d *ReconfigDaemon) runDrainWorker() {
+	for d.processNextDrain() {
+	}
+}
+
+func (d *ReconfigDaemon) processNextDrain() bool {
+	key, quit := d.drains.Get()
+	if quit {
+		return false
+	}
+	defer d.drains.Done(key)
+
+	nodeName := key.(string)
+	node, err := d.nodeLister.Get(nodeName)
+	if err != nil {
+		// the node is gone, nothing is left to report on
+		return true
+	}
+	if !d.inMaintenance(node) {
+		d.setDrainedCondition(node, v1.ConditionFalse, reasonNotInMaintenance, "Node is not in maintenance")
+		return true
+	}
+
+	owned, err := d.drainNode(node)
+	if err != nil {
+		klog.ErrorS(err, "Failed to drain GPUs of the node", "node", nodeName)
+		d.drains.AddAfter(nodeName, drainRecheckPeriod)
+		return true
+	}
+	if owned > 0 {
+		// The owned GPUs are released as the pods are evicted, so the node is drained again later
+		d.setDrainedCondition(node, v1.ConditionFalse, reasonGPUsInUse,
+			fmt.Sprintf("%d pool GPU(s) are still owned by pods on the node", owned))
+		d.drains.AddAfter(nodeName, drainRecheckPeriod)
+		return true
+	}
+	d.setDrainedCondition(node, v1.ConditionTrue, reasonGPUsDrained, "No pool GPU is attached to the node")
+	return true
+}
+
+// Detaches the free GPUs of the node into the unassigned state, returns the GPUs still owned by its pods
+func (d *ReconfigDaemon) drainNode(node *v1.Node) (int, error) {
+	port := d.nodePorts()[node.Name]
+	if err := d.updateDevice(); err != nil {
+		return 0, fmt.Errorf("failed to update devices: %v", err)
+	}
+
+	var attached []string
+	for dev, devPort := range d.getDeviceAlloc() {
+		if devPort == port {
+			attached = append(attached, dev)
+		}
+	}
+	if len(attached) == 0 {
+		return 0, nil
+	}
+	sort.Strings(attached)
+
+	// The devices of the pods which are not running yet are unknown, and a pod in Permit or binding may be about
+	// to take the free GPUs, so nothing is detached until they are known
+	for _, nodeName := range d.pods.notReadyNodes("", "") {
+		if nodeName == node.Name {
+			return len(attached), nil
+		}
+	}
+
+	usedGPUs := d.usedDevices()
+	var free []string
+	for _, dev := range attached {
+		if !usedGPUs.Has(dev) {
+			free = append(free, dev)
+		}
+	}
+	owned := len(attached) - len(free)
+	if len(free) == 0 {
+		return owned, nil
+	}
+
+	klog.InfoS("Node is in maintenance, detaching its free GPUs", "node", node.Name, "devids", free)
+	d.recorder.Eventf(node, v1.EventTypeNormal, reasonGPUDrainStarted, "Detaching %d free pool GPU(s) from the node in maintenance", len(free))
+	if d.dryRun {
+		klog.InfoS("Dry run, the devices would be detached", "node", node.Name, "devids", free)
+		return owned, nil
+	}
+
+	var errs []error
+	for _, dev := range free {
+		if err := d.reclaimDevice(dev, port, moveForDrain); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		d.recorder.Eventf(node, v1.EventTypeNormal, reasonGPUDetached, "Detached pool GPU %s into the unassigned pool", dev)
+	}
+	if err := d.updateDevice(); err != nil {
+		klog.ErrorS(err, "Failed to update devices")
+	}
+	if len(errs) > 0 {
+		return owned, fmt.Errorf("%d device(s) could not be detached: %v", len(errs), errs)
+	}
+	return owned, nil
+}
+
+// Sets the drained condition of the node, the status is only written when the condition changes
+func (d *ReconfigDaemon) setDrainedCondition(node *v1.Node, status v1.ConditionStatus, reason string, message string) {
+	index := -1
+	for i, cond := range node.Status.Conditions {
+		if cond.Type == conditionGPUsDrained {
+			index = i
+		}
+	}
+	if index < 0 && reason == reasonNotInMaintenance {
+		// a node which never entered maintenance carries no condition
+		return
+	}
+	now := metav1.Now()
+	condition := v1.NodeCondition{
+		Type:               conditionGPUsDrained,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	}
+	updated := node.DeepCopy()
+	if index < 0 {
+		updated.Status.Conditions = append(updated.Status.Conditions, condition)
+	} else {
+		cond := node.Status.Conditions[index]
+		if cond.Status == status && cond.Reason == reason && cond.Message == message {
+			return
+		}
+		if cond.Status == status {
+			condition.LastTransitionTime = cond.LastTransitionTime
+		}
+		updated.Status.Conditions[index] = condition
+	}
+
+	if _, err := d.clientset.CoreV1().Nodes().UpdateStatus(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		klog.ErrorS(err, "Failed to set the node condition", "node", node.Name, "condition", conditionGPUsDrained)
+		return
+	}
+	if status == v1.ConditionTrue {
+		d.recorder.Event(node, v1.EventTypeNormal, reasonGPUsDrained, message)
+	}
+}
+
+// Returns the host ports of the nodes in maintenance or fenced, which never receive GPUs
+func (d *ReconfigDaemon) closedPorts() sets.Set[string] {
+	ports := sets.New[string]()
+	for nodeName, port := range d.nodePorts() {
+		node, err := d.nodeLister.Get(nodeName)
+		if err == nil && (d.inMaintenance(node) || hasFenceTaint(node)) {
+			ports.Insert(port)
+		}
+	}
+	return ports
+}
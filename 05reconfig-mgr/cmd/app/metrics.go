@@ -0,0 +1,95 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Reasons a device is moved, used as the reason label of devicesMoved
+const (
+	moveForRequest   string = "request"
+	moveForRebalance string = "rebalance"
+	moveForReclaim   string = "reclaim"
+	moveForDrain     string = "drain"
+	moveForFence     string = "fence"
+	moveForRollback  string = "rollback"
+	moveForClaim     string = "claim"    // attached or detached for a ResourceClaim of the DRA driver
+	moveForOperator  string = "operator" // moved by an operator through the kubectl plugin
+)
+
+var (
+	reconfigRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kubecomp_reconfig_requests_total",
+		Help: "Reconfiguration requests queued, from the Reconfig events and the rescan of pending pods.",
+	})
+	reconfigOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubecomp_reconfig_outcomes_total",
+		Help: "Outcomes of the reconfigurations reported on pods.",
+	}, []string{"outcome"})
+	reconfigDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubecomp_reconfig_duration_seconds",
+		Help:    "Time to apply a reconfiguration plan, including the rollback on failure.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"outcome"})
+	devicesMoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubecomp_devices_moved_total",
+		Help: "Devices attached to or detached from a host port by reconfig-mgr.",
+	}, []string{"reason"})
+	deviceOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubecomp_device_operation_duration_seconds",
+		Help:    "Latency of the assign and unassign calls to the resource pool.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"operation"})
+	poolAPIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubecomp_pool_api_requests_total",
+		Help: "Requests to the resource pool API by operation and result.",
+	}, []string{"operation", "result"})
+	poolGPUs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubecomp_pool_gpus",
+		Help: "Pool GPUs by host port and state, free or owned by a pod. The host port is empty for the unassigned GPUs.",
+	}, []string{"host_port", "node", "state"})
+)
+
+// Counts a call to the resource pool API, and observes its latency for the device operations
+func observePoolAPI(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	poolAPIRequests.WithLabelValues(operation, result).Inc()
+	if operation != "get_resources" {
+		deviceOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Sets the free and owned GPUs of every host port from the device allocation
+func (d *ReconfigDaemon) updatePoolMetrics(deviceAlloc map[string]string, usedGPUs sets.Set[string]) {
+	portToNode := make(map[string]string, len(d.nodePorts()))
+	for nodeName, port := range d.nodePorts() {
+		portToNode[port] = nodeName
+	}
+
+	free := make(map[string]int)
+	owned := make(map[string]int)
+	for port := range portToNode {
+		free[port], owned[port] = 0, 0
+	}
+	for dev, port := range deviceAlloc {
+		if usedGPUs.Has(dev) {
+			owned[port]++
+		} else {
+			free[port]++
+		}
+	}
+
+	poolGPUs.Reset()
+	for port, count := range free {
+		poolGPUs.WithLabelValues(port, portToNode[port], "free").Set(float64(count))
+	}
+	for port, count := range owned {
+		poolGPUs.WithLabelValues(port, portToNode[port], "owned").Set(float64(count))
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// moveRequest asks reconfig-mgr to attach, move or detach one device, sent by the DRA driver and the kubectl plugin
+type moveRequest struct {
+	DevID    string  `json:"devid"`
+	HostPort string  `json:"hostport"`                // host port to attach the device to, empty to detach it
+	From     *string `json:"from_hostport,omitempty"` // refuses the move unless the device is on this host port, empty if unassigned
+	Force    bool    `json:"force"`                   // moves the device even if a pod owns it or the DRA driver reserved it
+}
+
+// moveResult is the device as moved by a moveRequest
+type moveResult struct {
+	DevID    string `json:"devid"`
+	FromPort string `json:"from_port"`
+	ToPort   string `json:"to_port"`
+}
+
+// Handles the POST /move request. The device is moved by the leader with its fencing token, under the inflight set,
+// so that it never races a reconfiguration, the rebalancer or the reclaimer.
+// The caller must be allowed to update the PoolDevice of the device, and a device reserved by the DRA driver is only
+// moved by a caller allowed to update the ConfigMap of the reservations, or forced.
+func (d *ReconfigDaemon) postMove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DevID == "" {
+		http.Error(w, "Invalid request payload, devid is required", http.StatusBadRequest)
+		return
+	}
+	user, ok := d.authorize(w, r, moveAccess(req.DevID))
+	if !ok {
+		return
+	}
+	if err := d.updateDevice(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to update devices: %v", err), http.StatusBadGateway)
+		return
+	}
+	fromPort, ok := d.getDeviceAlloc()[req.DevID]
+	if !ok {
+		http.Error(w, fmt.Sprintf("device %s not found in the pool", req.DevID), http.StatusNotFound)
+		return
+	}
+	if req.From != nil && *req.From != fromPort {
+		http.Error(w, fmt.Sprintf("device %s is on host port %q, not %q", req.DevID, fromPort, *req.From), http.StatusConflict)
+		return
+	}
+	result := moveResult{DevID: req.DevID, FromPort: fromPort, ToPort: req.HostPort}
+	if fromPort == req.HostPort {
+		writeJSON(w, result)
+		return
+	}
+	if req.HostPort != "" && d.closedPorts().Has(req.HostPort) {
+		http.Error(w, fmt.Sprintf("host port %s is in maintenance or fenced", req.HostPort), http.StatusConflict)
+		return
+	}
+	reserved := d.reserved.get().Has(req.DevID)
+	holder := false
+	if reserved {
+		var err error
+		if holder, err = d.allowed(r.Context(), user, d.reservationAccess()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Holds the device and its host ports like a reconfiguration, the owners are checked once nothing else can take it
+	targets := sets.New[string]()
+	if req.HostPort != "" {
+		targets.Insert(req.HostPort)
+	}
+	if !d.inflight.acquireTargets(targets) {
+		http.Error(w, fmt.Sprintf("host port %s is being reconfigured", req.HostPort), http.StatusConflict)
+		return
+	}
+	d.inflight.Lock()
+	if d.inflight.devices.Has(req.DevID) || (fromPort != "" && d.inflight.targets.Has(fromPort)) {
+		d.inflight.Unlock()
+		d.inflight.release(targets, nil)
+		http.Error(w, fmt.Sprintf("device %s or host port %s is being reconfigured", req.DevID, fromPort), http.StatusConflict)
+		return
+	}
+	d.inflight.devices.Insert(req.DevID)
+	d.inflight.donors.Insert(fromPort)
+	d.inflight.Unlock()
+	defer d.inflight.release(targets, []gpuOption{{devGID: req.DevID, hostPort: fromPort}})
+
+	if !req.Force {
+		if owner := d.pods.owners()[req.DevID]; owner != "" {
+			http.Error(w, fmt.Sprintf("device %s is used by pod %s", req.DevID, owner), http.StatusConflict)
+			return
+		}
+		if d.unresolvedDevices().Has(req.DevID) {
+			http.Error(w, fmt.Sprintf("device %s may be used by a pod of host port %s whose devices are unknown yet", req.DevID, fromPort), http.StatusConflict)
+			return
+		}
+		if reserved && !holder {
+			http.Error(w, fmt.Sprintf("device %s is reserved by the DRA driver for a ResourceClaim", req.DevID), http.StatusConflict)
+			return
+		}
+	}
+
+	reason := moveForOperator
+	if holder {
+		reason = moveForClaim
+	}
+	ctx, span := tracer.Start(r.Context(), "ReconfigDaemon.move",
+		trace.WithAttributes(attribute.String("devid", req.DevID), attribute.String("hostport", req.HostPort), attribute.String("reason", reason)))
+	defer span.End()
+	ctx = klog.NewContext(ctx, klog.LoggerWithValues(klog.Background(), "requestID", requestID(ctx), "reason", reason))
+	if err := d.moveDevice(ctx, req.DevID, fromPort, req.HostPort, reason); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	klog.FromContext(ctx).Info("Moved the device", "devid", req.DevID, "from", fromPort, "to", req.HostPort, "user", user.Username)
+	writeJSON(w, result)
+}
+
+// Moves the device from its host port to another one, or detaches it if toPort is empty.
+// A failed attachment is rolled back to the original host port.
+func (d *ReconfigDaemon) moveDevice(ctx context.Context, devGID string, fromPort string, toPort string, reason string) error {
+	if toPort == "" {
+		if err := d.unassign(ctx, devGID); err != nil {
+			return fmt.Errorf("unassign device %s from port %s: %v", devGID, fromPort, err)
+		}
+		devicesMoved.WithLabelValues(reason).Inc()
+		return nil
+	}
+
+	targetNode := toPort
+	for nodeName, port := range d.nodePorts() {
+		if port == toPort {
+			targetNode = nodeName
+		}
+	}
+	txn := &reconfigTxn{reason: reason, steps: []*reconfigStep{{devGID: devGID, fromPort: fromPort, toPort: toPort, targetNode: targetNode}}}
+	if outcome, err := d.applyTxn(ctx, txn); err != nil {
+		return fmt.Errorf("moving device %s is %s: %v", devGID, outcome, err)
+	}
+	return nil
+}
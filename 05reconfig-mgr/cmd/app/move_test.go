@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newAuthClientset returns a clientset whose tokens are the names of their users, the operator may update the
+// PoolDevices and the driver may update the ConfigMap of the reservations as well
+func newAuthClientset() *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "operator" || review.Spec.Token == "driver" || review.Spec.Token == "nobody" {
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: review.Spec.Token}}
+		}
+		return true, review, nil
+	})
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		switch {
+		case attrs == nil:
+		case attrs.Resource == "pooldevices":
+			review.Status.Allowed = review.Spec.User == "operator" || review.Spec.User == "driver"
+		case attrs.Resource == "configmaps" && attrs.Name == "pool-dra-reserved":
+			review.Status.Allowed = review.Spec.User == "driver"
+		}
+		return true, review, nil
+	})
+	return clientset
+}
+
+// A move is authenticated and authorized, a reserved device is only moved by the holder of the reservation, and no
+// device is moved to a fenced node
+func TestPostMove(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		body      string
+		wantCode  int
+		wantAlloc map[string]string
+	}{
+		{
+			name:     "no token",
+			body:     `{"devid": "u1", "hostport": "T"}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "invalid token",
+			token:    "stolen",
+			body:     `{"devid": "u1", "hostport": "T"}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "user not allowed to update the PoolDevice",
+			token:    "nobody",
+			body:     `{"devid": "u1", "hostport": "T"}`,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:      "operator attaches an unassigned device",
+			token:     "operator",
+			body:      `{"devid": "u1", "hostport": "T"}`,
+			wantCode:  http.StatusOK,
+			wantAlloc: map[string]string{"u1": "T", "r1": ""},
+		},
+		{
+			name:     "operator claiming the reservation of a reserved device",
+			token:    "operator",
+			body:     `{"devid": "r1", "hostport": "T", "reserved": true}`,
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:      "driver attaches the device it reserved",
+			token:     "driver",
+			body:      `{"devid": "r1", "hostport": "T"}`,
+			wantCode:  http.StatusOK,
+			wantAlloc: map[string]string{"u1": "", "r1": "T"},
+		},
+		{
+			name:     "fenced node",
+			token:    "driver",
+			body:     `{"devid": "r1", "hostport": "F", "force": true}`,
+			wantCode: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			nodeIndexer.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1"}})
+			nodeIndexer.Add(&v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "n2"},
+				Spec:       v1.NodeSpec{Taints: []v1.Taint{{Key: fenceTaintKey, Effect: v1.TaintEffectNoSchedule}}},
+			})
+			pool := &fakePool{alloc: map[string]string{"u1": "", "r1": ""}}
+			d := &ReconfigDaemon{
+				clientset:           newAuthClientset(),
+				devIF:               pool,
+				lastMoved:           make(map[string]time.Time),
+				lastPools:           make(map[string]string),
+				nodeLister:          corelisters.NewNodeLister(nodeIndexer),
+				nodeNameToPort:      map[string]string{"n1": "T", "n2": "F"},
+				pods:                newPodIndex(nil),
+				reserved:            &reservedDevices{devices: sets.New("r1")},
+				inflight:            newInflightSet(),
+				unassignedNamespace: "kubecomp",
+				unassignedConfigMap: "pool-unassigned",
+				reservedConfigMap:   "pool-dra-reserved",
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/move", strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set(tokenHeader, "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			d.postMove(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("POST /move = %d %s, want %d", rec.Code, strings.TrimSpace(rec.Body.String()), tt.wantCode)
+			}
+			want := tt.wantAlloc
+			if want == nil {
+				want = map[string]string{"u1": "", "r1": ""}
+			}
+			for dev, port := range want {
+				if pool.alloc[dev] != port {
+					t.Errorf("device %s on host port %q, want %q", dev, pool.alloc[dev], port)
+				}
+			}
+		})
+	}
+}
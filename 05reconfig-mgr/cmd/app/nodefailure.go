@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Taint on the nodes whose devices were force-detached. With the NoSchedule effect no new pod is placed on the
+	// node, while its other pods and the DaemonSets keep running, only the GPU pods are deleted by reconfig-mgr.
+	fenceTaintKey string = "falcon.com/gpu-fenced"
+
+	fenceRecheckPeriod time.Duration = 10 * time.Second // time between two checks of a fenced node which is back
+
+	reasonNodeFenced   string = "GPUsForceDetached"
+	reasonNodeUnfenced string = "GPUFenceLifted"
+)
+
+// Returns since when the node is not ready, or false if it is ready
+func nodeNotReadySince(node *v1.Node) (time.Time, bool) {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			if cond.Status == v1.ConditionTrue {
+				return time.Time{}, false
+			}
+			return cond.LastTransitionTime.Time, true
+		}
+	}
+	// a node which never reported is not ready since it was created
+	return node.CreationTimestamp.Time, true
+}
+
+func hasFenceTaint(node *v1.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == fenceTaintKey {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the event handlers which queue the nodes whose readiness or fencing changes
+func (d *ReconfigDaemon) nodeFailureEventHandler() cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		node, ok := obj.(*v1.Node)
+		if !ok {
+			return
+		}
+		if _, ok := d.nodePorts()[node.Name]; !ok {
+			return
+		}
+		// The fenced nodes are known from their taint, also after a restart
+		d.pods.setFenced(node.Name, hasFenceTaint(node))
+		if _, notReady := nodeNotReadySince(node); notReady || hasFenceTaint(node) {
+			d.failures.Add(node.Name)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(_, obj interface{}) {
+			enqueue(obj)
+		},
+	}
+}
+
+// Handles the failed nodes one at a time
+func (d *ReconfigDaemon) runNodeFailureWorker() {
+	for d.processNextNodeFailure() {
+	}
+}
+
+func (d *ReconfigDaemon) processNextNodeFailure() bool {
+	key, quit := d.failures.Get()
+	if quit {
+		return false
+	}
+	defer d.failures.Done(key)
+
+	nodeName := key.(string)
+	node, err := d.nodeLister.Get(nodeName)
+	if err != nil {
+		return true
+	}
+
+	since, notReady := nodeNotReadySince(node)
+	switch {
+	case notReady && !hasFenceTaint(node):
+		if wait := d.notReadyGrace - time.Since(since); wait > 0 {
+			d.failures.AddAfter(nodeName, wait)
+			return true
+		}
+		if err := d.fenceNode(node, since); err != nil {
+			klog.ErrorS(err, "Failed to recover the GPUs of the node", "node", nodeName)
+			d.failures.AddAfter(nodeName, fenceRecheckPeriod)
+		}
+	case notReady:
+		// Detaches the devices attached to a fenced node since, e.g. by hand
+		if err := d.forceDetach(node); err != nil {
+			klog.ErrorS(err, "Failed to recover the GPUs of the node", "node", nodeName)
+			d.failures.AddAfter(nodeName, fenceRecheckPeriod)
+		}
+	case hasFenceTaint(node):
+		if err := d.unfenceNode(node); err != nil {
+			klog.ErrorS(err, "Node is back but stays fenced", "node", nodeName)
+			d.failures.AddAfter(nodeName, fenceRecheckPeriod)
+		}
+	}
+	return true
+}
+
+// Taints the node, deletes its GPU pods and force-detaches all of its devices, including the devices of its pods
+func (d *ReconfigDaemon) fenceNode(node *v1.Node, since time.Time) error {
+	klog.InfoS("Node is not ready, fencing it and detaching its GPUs", "node", node.Name, "notReadySince", since.Format(time.RFC3339))
+	if d.dryRun {
+		klog.InfoS("Dry run, the node would be fenced", "node", node.Name)
+		return nil
+	}
+
+	// The taint and the deletion go first, so that no GPU pod runs again on the node with the detached devices
+	updated := node.DeepCopy()
+	updated.Spec.Taints = append(updated.Spec.Taints, v1.Taint{
+		Key:    fenceTaintKey,
+		Effect: v1.TaintEffectNoSchedule,
+	})
+	if _, err := d.clientset.CoreV1().Nodes().Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to taint the node: %v", err)
+	}
+	d.pods.setFenced(node.Name, true)
+	if err := d.deleteGPUPods(node); err != nil {
+		klog.ErrorS(err, "Failed to delete the GPU pods of the fenced node, retrying when it is back", "node", node.Name)
+	}
+
+	return d.forceDetach(node)
+}
+
+// Deletes the GPU pods of the node, which lost their devices. They are deleted rather than evicted, since no
+// disruption budget can keep them running without their GPUs, and terminate once the node is back.
+func (d *ReconfigDaemon) deleteGPUPods(node *v1.Node) error {
+	var errs []error
+	for _, pod := range d.pods.podsOnNode(node.Name) {
+		err := d.clientset.CoreV1().Pods(pod.Namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+			continue
+		}
+		klog.V(2).InfoS("Deleted the GPU pod of the fenced node", "pod", klog.KRef(pod.Namespace, pod.Name), "node", node.Name)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d pod(s) could not be deleted: %v", len(errs), errs)
+	}
+	return nil
+}
+
+// Detaches every device attached to the port of the node
+func (d *ReconfigDaemon) forceDetach(node *v1.Node) error {
+	port := d.nodePorts()[node.Name]
+	if err := d.updateDevice(); err != nil {
+		return fmt.Errorf("failed to update devices: %v", err)
+	}
+
+	var detached []string
+	var errs []error
+	for dev, devPort := range d.getDeviceAlloc() {
+		if devPort != port {
+			continue
+		}
+		if err := d.reclaimDevice(dev, port, moveForFence); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		detached = append(detached, dev)
+	}
+	if len(detached) > 0 {
+		klog.InfoS("Force-detached devices", "node", node.Name, "devids", detached)
+		d.recorder.Eventf(node, v1.EventTypeWarning, reasonNodeFenced,
+			"Node is not ready for more than %v, force-detached pool GPU(s) %v into the unassigned pool", d.notReadyGrace, detached)
+		if err := d.updateDevice(); err != nil {
+			klog.ErrorS(err, "Failed to update devices")
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d device(s) could not be detached: %v", len(errs), errs)
+	}
+	return nil
+}
+
+// Lifts the fence once the node is ready and the pods which owned the detached devices are gone
+func (d *ReconfigDaemon) unfenceNode(node *v1.Node) error {
+	if pods := d.pods.podsOnNode(node.Name); len(pods) > 0 {
+		d.failures.AddAfter(node.Name, fenceRecheckPeriod)
+		klog.V(2).InfoS("Node is back, waiting for the GPU pods to terminate before lifting the fence", "node", node.Name, "pods", pods)
+		return d.deleteGPUPods(node)
+	}
+
+	updated := node.DeepCopy()
+	updated.Spec.Taints = nil
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != fenceTaintKey {
+			updated.Spec.Taints = append(updated.Spec.Taints, taint)
+		}
+	}
+	if _, err := d.clientset.CoreV1().Nodes().Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to remove the taint: %v", err)
+	}
+	d.pods.setFenced(node.Name, false)
+	klog.InfoS("Node is back and its GPU pods are gone, the fence is lifted", "node", node.Name)
+	d.recorder.Event(node, v1.EventTypeNormal, reasonNodeUnfenced, "Node is ready and no pod uses the detached GPUs, it can receive pool GPUs again")
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// Fencing a node keeps its other pods and DaemonSets running, only its GPU pods are deleted and its GPUs detached
+func TestFenceNode(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1"}}
+	gpuPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu", Namespace: "default", UID: "gpu", Annotations: map[string]string{"use_falcon": "true"}},
+		Spec:       v1.PodSpec{NodeName: "n1"},
+		Status:     v1.PodStatus{Phase: v1.PodPending},
+	}
+	otherPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-plugin", Namespace: "kubecomp", UID: "device-plugin"},
+		Spec:       v1.PodSpec{NodeName: "n1"},
+	}
+	clientset := fake.NewSimpleClientset(node, gpuPod, otherPod)
+	pool := &fakePool{alloc: map[string]string{"d1": "T", "d2": "T", "d3": "A"}}
+	d := &ReconfigDaemon{
+		clientset:           clientset,
+		devIF:               pool,
+		lastMoved:           make(map[string]time.Time),
+		lastPools:           make(map[string]string),
+		nodeNameToPort:      map[string]string{"n1": "T"},
+		pods:                newPodIndex(nil),
+		reserved:            &reservedDevices{devices: sets.New[string]()},
+		inflight:            newInflightSet(),
+		recorder:            record.NewFakeRecorder(10),
+		notReadyGrace:       time.Minute,
+		unassignedNamespace: "kubecomp",
+		unassignedConfigMap: "pool-unassigned",
+	}
+	d.pods.upsert(gpuPod)
+	d.pods.upsert(otherPod)
+
+	if err := d.fenceNode(node, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	fenced, err := clientset.CoreV1().Nodes().Get(context.Background(), "n1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fenced.Spec.Taints) != 1 || fenced.Spec.Taints[0].Key != fenceTaintKey || fenced.Spec.Taints[0].Effect != v1.TaintEffectNoSchedule {
+		t.Errorf("taints = %+v, want %s:NoSchedule", fenced.Spec.Taints, fenceTaintKey)
+	}
+	if _, err := clientset.CoreV1().Pods("default").Get(context.Background(), "gpu", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("GPU pod of the fenced node: error = %v, want it deleted", err)
+	}
+	if _, err := clientset.CoreV1().Pods("kubecomp").Get(context.Background(), "device-plugin", metav1.GetOptions{}); err != nil {
+		t.Errorf("pod without GPUs of the fenced node: error = %v, want it kept", err)
+	}
+	for dev, want := range map[string]string{"d1": "", "d2": "", "d3": "A"} {
+		if pool.alloc[dev] != want {
+			t.Errorf("device %s on host port %q, want %q", dev, pool.alloc[dev], want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// Backoff between two attempts to fetch the devices of a pod
+const (
+	resolveBaseDelay time.Duration = time.Second
+	resolveMaxDelay  time.Duration = 2 * time.Minute
+)
+
+type PodInfo struct {
+	name      string
+	namespace string
+	nodeName  string
+	assumed   []string // nodes the pod is nominated or assumed on while it is not bound yet
+	phase     v1.PodPhase
+	gids      []string // Global ids for devices, empty until they are fetched from the pod
+	resolving bool     // gids are being fetched from the pod, or will be after a failure
+	failures  int      // consecutive failures to fetch the gids
+}
+
+// podIndex is an informer-backed index of the pods using falcon GPUs and the devices they own.
+// It holds the scheduled pods, and the pods in Permit or binding which are nominated or assumed on some nodes.
+// It is updated incrementally by pod events instead of listing every pod in the cluster.
+type podIndex struct {
+	sync.RWMutex
+	pods       map[types.UID]*PodInfo
+	fenced     sets.Set[string] // nodes whose devices were force-detached, their pods own nothing
+	resolveGID func(name string, namespace string) ([]string, error)
+}
+
+func newPodIndex(resolveGID func(name string, namespace string) ([]string, error)) *podIndex {
+	return &podIndex{
+		pods:       make(map[types.UID]*PodInfo),
+		fenced:     sets.New[string](),
+		resolveGID: resolveGID,
+	}
+}
+
+// Marks the node as fenced or not, the pods on a fenced node are ignored
+func (idx *podIndex) setFenced(nodeName string, fenced bool) {
+	idx.Lock()
+	defer idx.Unlock()
+	if fenced {
+		idx.fenced.Insert(nodeName)
+	} else {
+		idx.fenced.Delete(nodeName)
+	}
+}
+
+// Returns the indexed pods bound to the node, including the pods of a fenced node
+func (idx *podIndex) podsOnNode(nodeName string) []types.NamespacedName {
+	idx.RLock()
+	defer idx.RUnlock()
+	var pods []types.NamespacedName
+	for _, info := range idx.pods {
+		if info.nodeName == nodeName {
+			pods = append(pods, types.NamespacedName{Namespace: info.namespace, Name: info.name})
+		}
+	}
+	return pods
+}
+
+// Returns the event handlers which keep the index up to date
+func (idx *podIndex) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if po, ok := obj.(*v1.Pod); ok {
+				idx.upsert(po)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if po, ok := obj.(*v1.Pod); ok {
+				idx.upsert(po)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if po, ok := obj.(*v1.Pod); ok {
+				idx.remove(po.UID)
+			}
+		},
+	}
+}
+
+func podIsScheduled(po *v1.Pod) bool {
+	for _, cond := range po.Status.Conditions {
+		if cond.Type == v1.PodScheduled {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Returns the nodes a pod which is not bound yet is about to run on: its nominated node, the target node
+// requested by the scheduler in Permit, or every node of the plan of its pod group
+func podAssumedNodes(po *v1.Pod) []string {
+	nodes := sets.New[string]()
+	if po.Status.NominatedNodeName != "" {
+		nodes.Insert(po.Status.NominatedNodeName)
+	}
+	if nodeName := po.Annotations["dst_node"]; nodeName != "" {
+		nodes.Insert(nodeName)
+	}
+	var plan map[string]int
+	if err := json.Unmarshal([]byte(po.Annotations["gang_plan"]), &plan); err == nil {
+		for nodeName := range plan {
+			nodes.Insert(nodeName)
+		}
+	}
+	return sets.List(nodes)
+}
+
+func podUseFalcon(po *v1.Pod) bool {
+	return po.ObjectMeta.Annotations["use_falcon"] == "true"
+}
+
+func (idx *podIndex) upsert(po *v1.Pod) {
+	// A pod being bound has its node before the PodScheduled condition is seen
+	bound := podIsScheduled(po) || po.Spec.NodeName != ""
+	var assumed []string
+	if !bound {
+		assumed = podAssumedNodes(po)
+	}
+	if !podUseFalcon(po) || (!bound && len(assumed) == 0) || po.Status.Phase == v1.PodSucceeded || po.Status.Phase == v1.PodFailed {
+		idx.remove(po.UID)
+		return
+	}
+
+	idx.Lock()
+	info, ok := idx.pods[po.UID]
+	if !ok {
+		info = &PodInfo{
+			name:      po.Name,
+			namespace: po.Namespace,
+		}
+		idx.pods[po.UID] = info
+	}
+	info.nodeName = po.Spec.NodeName
+	info.assumed = assumed
+	info.phase = po.Status.Phase
+
+	// The devices of a running pod are fetched once, outside the informer callback
+	if info.phase == v1.PodRunning && len(info.gids) == 0 && !info.resolving {
+		info.resolving = true
+		go idx.resolve(po.UID, po.Name, po.Namespace)
+	}
+	idx.Unlock()
+}
+
+// Fetches the devices of the pod, a failure is retried with a backoff while the pod stays unresolved
+func (idx *podIndex) resolve(uid types.UID, name string, namespace string) {
+	gids, err := idx.resolveGID(name, namespace)
+
+	idx.Lock()
+	defer idx.Unlock()
+	info, ok := idx.pods[uid]
+	if !ok {
+		return
+	}
+	if err == nil {
+		info.gids = gids
+		info.resolving = false
+		info.failures = 0
+		return
+	}
+	info.failures++
+	delay := resolveBaseDelay << (info.failures - 1)
+	if delay > resolveMaxDelay || delay <= 0 {
+		delay = resolveMaxDelay
+	}
+	klog.ErrorS(err, "Failed to fetch the devices of the pod, its node is unsafe until they are known", "pod", klog.KRef(namespace, name), "retryIn", delay)
+	time.AfterFunc(delay, func() { idx.retry(uid, name, namespace) })
+}
+
+func (idx *podIndex) retry(uid types.UID, name string, namespace string) {
+	idx.Lock()
+	defer idx.Unlock()
+	info, ok := idx.pods[uid]
+	if !ok {
+		return
+	}
+	if info.phase != v1.PodRunning || len(info.gids) > 0 {
+		info.resolving = false
+		return
+	}
+	go idx.resolve(uid, name, namespace)
+}
+
+func (idx *podIndex) remove(uid types.UID) {
+	idx.Lock()
+	defer idx.Unlock()
+	delete(idx.pods, uid)
+}
+
+// Returns the nodes of the pods whose devices are not known yet, and the nodes the pods in Permit or binding
+// are assumed on, except for the given pod
+func (idx *podIndex) notReadyNodes(name string, namespace string) []string {
+	idx.RLock()
+	defer idx.RUnlock()
+	nodes := sets.New[string]()
+	for _, info := range idx.pods {
+		if info.name == name && info.namespace == namespace {
+			continue
+		}
+		if info.nodeName == "" {
+			for _, nodeName := range info.assumed {
+				if !idx.fenced.Has(nodeName) {
+					nodes.Insert(nodeName)
+				}
+			}
+			continue
+		}
+		if idx.fenced.Has(info.nodeName) {
+			continue
+		}
+		if info.phase == v1.PodPending || len(info.gids) == 0 {
+			nodes.Insert(info.nodeName)
+		}
+	}
+	return sets.List(nodes)
+}
+
+// Returns the nodes of the bound pods whose devices are not known yet, except the fenced nodes.
+// Such a pod may own any device of its node.
+func (idx *podIndex) unresolvedNodes() sets.Set[string] {
+	idx.RLock()
+	defer idx.RUnlock()
+	nodes := sets.New[string]()
+	for _, info := range idx.pods {
+		if info.nodeName != "" && len(info.gids) == 0 && !idx.fenced.Has(info.nodeName) {
+			nodes.Insert(info.nodeName)
+		}
+	}
+	return nodes
+}
+
+// Returns the devices owned by the indexed pods, except the pods of fenced nodes
+func (idx *podIndex) usedDevices() sets.Set[string] {
+	idx.RLock()
+	defer idx.RUnlock()
+	used := sets.New[string]()
+	for _, info := range idx.pods {
+		if idx.fenced.Has(info.nodeName) {
+			continue
+		}
+		used.Insert(info.gids...)
+	}
+	return used
+}
+
+// Returns the pod owning each device as namespace/name, including the pods of fenced nodes
+func (idx *podIndex) owners() map[string]string {
+	idx.RLock()
+	defer idx.RUnlock()
+	owners := make(map[string]string)
+	for _, info := range idx.pods {
+		for _, gid := range info.gids {
+			owners[gid] = info.namespace + "/" + info.name
+		}
+	}
+	return owners
+}
+
+// Returns the number of indexed pods bound to each node which is not fenced
+func (idx *podIndex) podsPerNode() map[string]int {
+	idx.RLock()
+	defer idx.RUnlock()
+	counts := make(map[string]int)
+	for _, info := range idx.pods {
+		if info.nodeName == "" || idx.fenced.Has(info.nodeName) {
+			continue
+		}
+		counts[info.nodeName]++
+	}
+	return counts
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A pod whose devices could not be fetched is unresolved, not a pod without GPUs
+func TestPodIndexUnresolvedAfterFailedExec(t *testing.T) {
+	fail := true
+	idx := newPodIndex(func(name string, namespace string) ([]string, error) {
+		if fail {
+			return nil, errors.New("exec failed")
+		}
+		return []string{"3", "4"}, nil
+	})
+	po := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "uid1", Annotations: map[string]string{"use_falcon": "true"}},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	idx.upsert(po)
+	idx.resolve(po.UID, po.Name, po.Namespace)
+
+	if !idx.unresolvedNodes().Has("node1") {
+		t.Errorf("unresolvedNodes() = %v after a failed exec, want node1", idx.unresolvedNodes())
+	}
+	if nodes := idx.notReadyNodes("", ""); len(nodes) != 1 || nodes[0] != "node1" {
+		t.Errorf("notReadyNodes() = %v after a failed exec, want [node1]", nodes)
+	}
+
+	// Another update of the pod does not start a second fetch while the retry is pending
+	idx.upsert(po)
+	if info := idx.pods[po.UID]; !info.resolving || info.failures != 1 {
+		t.Errorf("resolving = %v, failures = %d, want a pending retry after one failure", info.resolving, info.failures)
+	}
+
+	fail = false
+	idx.resolve(po.UID, po.Name, po.Namespace)
+	if nodes := idx.unresolvedNodes(); nodes.Len() != 0 {
+		t.Errorf("unresolvedNodes() = %v once the devices are fetched, want none", nodes)
+	}
+	if used := idx.usedDevices(); !used.Has("3") || !used.Has("4") {
+		t.Errorf("usedDevices() = %v, want 3 and 4", used)
+	}
+}
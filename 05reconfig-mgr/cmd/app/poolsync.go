@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+
+	"kubecomp/topology"
+	"reconfig-daemon/pkg/apis/v1alpha1"
+	"reconfig-daemon/pkg/inter"
+)
+
+// Label of the objects written by the pool sync, the others are never modified nor deleted
+const managedByLabel string = "app.kubernetes.io/managed-by"
+
+// Mirrors GET /resources of the pool into the ComposablePool, PoolDevice and HostPort objects periodically
+func (d *ReconfigDaemon) runPoolSync(interval time.Duration, stopCh <-chan struct{}) {
+	client, err := dynamic.NewForConfig(d.config)
+	if err != nil {
+		klog.ErrorS(err, "Failed to create the dynamic client, the pool is not mirrored")
+		return
+	}
+	klog.InfoS("Mirroring the pool into the cluster", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.syncPool(context.Background(), client); err != nil {
+			klog.ErrorS(err, "Failed to mirror the pool")
+		}
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Writes the objects whose spec, status or labels changed since the last round, and deletes those gone from the pool
+func (d *ReconfigDaemon) syncPool(ctx context.Context, client dynamic.Interface) error {
+	start := time.Now()
+	devices, err := d.devIF.GetAllResource()
+	observePoolAPI("get_resources", start, err)
+	if err != nil {
+		return err
+	}
+
+	pools, ports, devs := d.poolObjects(devices)
+	for _, kind := range []struct {
+		gvr     schema.GroupVersionResource
+		desired map[string]interface{}
+	}{
+		{v1alpha1.ComposablePoolResource, pools},
+		{v1alpha1.HostPortResource, ports},
+		{v1alpha1.PoolDeviceResource, devs},
+	} {
+		if err := syncObjects(ctx, client.Resource(kind.gvr), kind.desired); err != nil {
+			return fmt.Errorf("%s: %w", kind.gvr.Resource, err)
+		}
+	}
+	klog.V(4).InfoS("Mirrored the pool", "pools", len(pools), "hostports", len(ports), "devices", len(devs))
+	return nil
+}
+
+// Builds the desired objects by name from the devices of the pool and the merged host ports of the topology and the node labels
+func (d *ReconfigDaemon) poolObjects(devices []inter.DevicePair) (pools map[string]interface{}, ports map[string]interface{}, devs map[string]interface{}) {
+	d.topoLock.RLock()
+	endpoints := d.topo.Endpoints
+	d.topoLock.RUnlock()
+	portPools := d.portPools()
+	portNodes := make(map[string]string)
+	for nodeName, port := range d.nodePorts() {
+		portNodes[port] = nodeName
+	}
+
+	attached := make(map[string]int) // HostPort to its devices
+	for _, dev := range devices {
+		attached[dev.HostPort]++
+	}
+	poolPorts := make(map[string]int)
+	poolDevices := make(map[string]int)
+	for port, pool := range portPools {
+		poolPorts[pool]++
+		poolDevices[pool] += attached[port]
+	}
+	if len(poolPorts) == 0 {
+		poolPorts[topology.DefaultPoolName] = 0
+	}
+
+	typeMeta := func(kind string) metav1.TypeMeta {
+		return metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: kind}
+	}
+	objectMeta := func(name string, kv ...string) metav1.ObjectMeta {
+		meta := metav1.ObjectMeta{Name: name, Labels: map[string]string{managedByLabel: "reconfig-mgr"}}
+		for i := 0; i+1 < len(kv); i += 2 {
+			if kv[i+1] != "" {
+				meta.Labels[kv[i]] = kv[i+1]
+			}
+		}
+		return meta
+	}
+
+	now := metav1.Now()
+	pools = make(map[string]interface{}, len(poolPorts))
+	for pool := range poolPorts {
+		pools[pool] = &v1alpha1.ComposablePool{
+			TypeMeta:   typeMeta("ComposablePool"),
+			ObjectMeta: objectMeta(pool),
+			Spec: v1alpha1.ComposablePoolSpec{
+				ResourcesEndpoint:  endpoints.Resources,
+				AllocationEndpoint: endpoints.Allocation,
+			},
+			Status: v1alpha1.ComposablePoolStatus{
+				HostPorts:       poolPorts[pool],
+				AttachedDevices: poolDevices[pool],
+				LastSyncTime:    &now,
+			},
+		}
+	}
+
+	ports = make(map[string]interface{}, len(portPools))
+	for port, pool := range portPools {
+		name := v1alpha1.HostPortName(port)
+		ports[name] = &v1alpha1.HostPort{
+			TypeMeta:   typeMeta("HostPort"),
+			ObjectMeta: objectMeta(name, v1alpha1.PoolLabel, pool, v1alpha1.NodeLabel, portNodes[port]),
+			Spec:       v1alpha1.HostPortSpec{Port: port, Pool: pool, NodeName: portNodes[port]},
+			Status:     v1alpha1.HostPortStatus{AttachedDevices: attached[port]},
+		}
+	}
+
+	// A device attached to a port unknown to the topology and the labels keeps its port, without a pool or node
+	devs = make(map[string]interface{}, len(devices))
+	for _, dev := range devices {
+		name := v1alpha1.PoolDeviceName(dev.DevID)
+		status := v1alpha1.PoolDeviceStatus{HostPort: dev.HostPort, Pool: portPools[dev.HostPort], Node: portNodes[dev.HostPort]}
+		devs[name] = &v1alpha1.PoolDevice{
+			TypeMeta: typeMeta("PoolDevice"),
+			ObjectMeta: objectMeta(name, v1alpha1.PoolLabel, status.Pool, v1alpha1.HostPortLabel, status.HostPort,
+				v1alpha1.NodeLabel, status.Node),
+			Spec:   v1alpha1.PoolDeviceSpec{DevID: dev.DevID, UUID: dev.UUID},
+			Status: status,
+		}
+	}
+	return pools, ports, devs
+}
+
+// Makes the objects of one resource managed by the pool sync match the desired ones.
+// A failure on one object is logged and the others are still synced, the first error is returned.
+func syncObjects(ctx context.Context, client dynamic.ResourceInterface, desired map[string]interface{}) error {
+	selector := labels.Set{managedByLabel: "reconfig-mgr"}.AsSelector().String()
+	list, err := client.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	failed := func(err error, msg string, name string) {
+		klog.ErrorS(err, msg, "name", name)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	existing := make(map[string]*unstructured.Unstructured, len(list.Items))
+	for i := range list.Items {
+		obj := &list.Items[i]
+		if _, ok := desired[obj.GetName()]; ok {
+			existing[obj.GetName()] = obj
+			continue
+		}
+		if err := client.Delete(ctx, obj.GetName(), metav1.DeleteOptions{}); err != nil {
+			failed(err, "Failed to delete the mirrored object", obj.GetName())
+		}
+	}
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired[name])
+		if err != nil {
+			failed(err, "Failed to convert the mirrored object", name)
+			continue
+		}
+		want := &unstructured.Unstructured{Object: content}
+		status, _, _ := unstructured.NestedMap(want.Object, "status")
+
+		obj, ok := existing[name]
+		if !ok {
+			// The status subresource is ignored on create, it is written right after
+			obj, err = client.Create(ctx, want, metav1.CreateOptions{})
+			if err != nil {
+				failed(err, "Failed to create the mirrored object", name)
+				continue
+			}
+		} else if !sameFields(obj, want, "spec") || !equality.Semantic.DeepEqual(obj.GetLabels(), want.GetLabels()) {
+			obj.SetLabels(want.GetLabels())
+			obj.Object["spec"] = want.Object["spec"]
+			obj, err = client.Update(ctx, obj, metav1.UpdateOptions{})
+			if err != nil {
+				failed(err, "Failed to update the mirrored object", name)
+				continue
+			}
+		}
+
+		if !sameFields(obj, want, "status") {
+			obj.Object["status"] = status
+			if _, err := client.UpdateStatus(ctx, obj, metav1.UpdateOptions{}); err != nil {
+				failed(err, "Failed to update the status of the mirrored object", name)
+			}
+		}
+	}
+	return firstErr
+}
+
+// Compares a field of two objects, a missing field equals an empty one
+func sameFields(a *unstructured.Unstructured, b *unstructured.Unstructured, field string) bool {
+	fieldA, _, _ := unstructured.NestedMap(a.Object, field)
+	fieldB, _, _ := unstructured.NestedMap(b.Object, field)
+	return equality.Semantic.DeepEqual(fieldA, fieldB)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// Layouts of the free GPUs the rebalancer works towards
+const (
+	layoutPacked string = "packed" // free GPUs are gathered on as few host ports as possible
+	layoutSpread string = "spread" // free GPUs are evened out across the host ports
+)
+
+// rebalancerConfig is read from the rebalance_* keys of the config file
+type rebalancerConfig struct {
+	interval time.Duration // time between two rounds, the rebalancer is disabled if zero
+	idle     time.Duration // time without reconfiguration requests before a round may start
+	budget   int           // maximum moves in a round
+	layout   string
+}
+
+// activityTracker records when reconfig-mgr last handled a request
+type activityTracker struct {
+	sync.Mutex
+	last time.Time
+}
+
+func (a *activityTracker) touch() {
+	a.Lock()
+	defer a.Unlock()
+	a.last = time.Now()
+}
+
+func (a *activityTracker) idleFor() time.Duration {
+	a.Lock()
+	defer a.Unlock()
+	return time.Since(a.last)
+}
+
+// Consolidates the free GPUs periodically while no reconfiguration is requested
+func (d *ReconfigDaemon) runRebalancer(cfg rebalancerConfig, stopCh <-chan struct{}) {
+	klog.InfoS("Rebalancing free GPUs", "interval", cfg.interval, "layout", cfg.layout, "budget", cfg.budget)
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if d.requests.Len() > 0 || d.activity.idleFor() < cfg.idle {
+				continue
+			}
+			d.rebalance(cfg)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Runs one round, each move is applied on its own so that a request arriving meanwhile waits for one move at most
+func (d *ReconfigDaemon) rebalance(cfg rebalancerConfig) {
+	if err := d.updateDevice(); err != nil {
+		klog.ErrorS(err, "Failed to update devices")
+		return
+	}
+
+	moves := d.rebalanceMoves(cfg.layout, cfg.budget)
+	if len(moves) == 0 {
+		return
+	}
+	if d.dryRun {
+		klog.InfoS("Dry run, the rebalancer would move devices", "moves", describeMoves(d.planMoves(moves)))
+		return
+	}
+
+	moved := 0
+	for _, dev := range moves {
+		// Stops as soon as a reconfiguration is requested
+		if d.requests.Len() > 0 {
+			break
+		}
+		if err := d.rebalanceDevice(dev); err != nil {
+			klog.ErrorS(err, "Rebalancing stopped")
+			break
+		}
+		moved++
+	}
+	klog.InfoS("Rebalancer moved GPUs", "moved", moved, "planned", len(moves))
+}
+
+// Returns at most budget moves of free GPUs between the host ports of the nodes in the same pool
+func (d *ReconfigDaemon) rebalanceMoves(layout string, budget int) []gpuOption {
+	portToNode := make(map[string]string, len(d.nodePorts()))
+	for nodeName, port := range d.nodePorts() {
+		portToNode[port] = nodeName
+	}
+
+	// GPUs owned by pods, and every GPU of the nodes whose pods' devices are unknown yet or which pods in Permit
+	// or binding are assumed on, are never touched
+	usedGPUs := d.usedDevices()
+	unsafePorts := sets.Set[string]{}
+	for _, nodeName := range d.pods.notReadyNodes("", "") {
+		unsafePorts.Insert(d.nodePorts()[nodeName])
+	}
+
+	// The nodes in maintenance are drained, and the fenced nodes are emptied, instead
+	unsafePorts = unsafePorts.Union(d.closedPorts())
+
+	free := make(map[string][]string) // HostPort to its free devices
+	for port := range portToNode {
+		if !unsafePorts.Has(port) {
+			free[port] = nil
+		}
+	}
+	d.inflight.Lock()
+	for dev, port := range d.getDeviceAlloc() {
+		if _, ok := free[port]; !ok || usedGPUs.Has(dev) || d.inflight.devices.Has(dev) {
+			continue
+		}
+		free[port] = append(free[port], dev)
+	}
+	d.inflight.Unlock()
+
+	// GPUs only move between the host ports of a pool, so each pool is balanced on its own
+	portPools := d.portPools()
+	poolPorts := make(map[string][]string)
+	for port := range free {
+		sort.Strings(free[port])
+		poolPorts[portPools[port]] = append(poolPorts[portPools[port]], port)
+	}
+	pools := make([]string, 0, len(poolPorts))
+	for pool := range poolPorts {
+		sort.Strings(poolPorts[pool])
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+
+	var moves []gpuOption
+	for _, pool := range pools {
+		for len(moves) < budget {
+			from, to := pickRebalancePorts(free, poolPorts[pool], layout)
+			if from == "" || to == "" {
+				break
+			}
+			dev := free[from][0]
+			free[from] = free[from][1:]
+			free[to] = append(free[to], dev)
+			moves = append(moves, gpuOption{devGID: dev, hostPort: from, targetNode: portToNode[to]})
+		}
+	}
+	return moves
+}
+
+// Returns the donor and the receiver of the next move, or empty ports if the layout is reached
+func pickRebalancePorts(free map[string][]string, ports []string, layout string) (string, string) {
+	switch layout {
+	case layoutPacked:
+		// drains the port with the fewest free GPUs into the one with the most
+		receiver := ""
+		for _, port := range ports {
+			if len(free[port]) > 0 && (receiver == "" || len(free[port]) > len(free[receiver])) {
+				receiver = port
+			}
+		}
+		donor := ""
+		for _, port := range ports {
+			if port != receiver && len(free[port]) > 0 && (donor == "" || len(free[port]) < len(free[donor])) {
+				donor = port
+			}
+		}
+		if receiver == "" || donor == "" {
+			return "", ""
+		}
+		return donor, receiver
+	case layoutSpread:
+		// moves from the port with the most free GPUs to the one with the fewest until they differ by one at most
+		donor, receiver := "", ""
+		for _, port := range ports {
+			if donor == "" || len(free[port]) > len(free[donor]) {
+				donor = port
+			}
+			if receiver == "" || len(free[port]) < len(free[receiver]) {
+				receiver = port
+			}
+		}
+		if donor == "" || len(free[donor])-len(free[receiver]) <= 1 {
+			return "", ""
+		}
+		return donor, receiver
+	}
+	return "", ""
+}
+
+// Moves one device to the target node, rolled back on failure like any reconfiguration
+func (d *ReconfigDaemon) rebalanceDevice(dev gpuOption) error {
+	targetPorts := sets.New(d.nodePorts()[dev.targetNode])
+	if !d.inflight.acquireTargets(targetPorts) {
+		return fmt.Errorf("node %s is being reconfigured", dev.targetNode)
+	}
+	d.inflight.Lock()
+	if d.inflight.devices.Has(dev.devGID) || d.inflight.targets.Has(dev.hostPort) {
+		d.inflight.Unlock()
+		d.inflight.release(targetPorts, nil)
+		return fmt.Errorf("device %s or port %s is being reconfigured", dev.devGID, dev.hostPort)
+	}
+	d.inflight.devices.Insert(dev.devGID)
+	d.inflight.donors.Insert(dev.hostPort)
+	d.inflight.Unlock()
+	defer d.inflight.release(targetPorts, []gpuOption{dev})
+
+	// The device may have been taken by a pod since the plan was made
+	if d.usedDevices().Has(dev.devGID) {
+		return fmt.Errorf("device %s is used by a pod", dev.devGID)
+	}
+
+	ctx, span := tracer.Start(context.Background(), "ReconfigDaemon.rebalance",
+		trace.WithAttributes(attribute.String("devid", dev.devGID), attribute.String("node", dev.targetNode)))
+	defer span.End()
+	ctx = klog.NewContext(ctx, klog.LoggerWithValues(klog.Background(), "requestID", requestID(ctx), "node", dev.targetNode))
+
+	txn := newReconfigTxn([]gpuOption{dev}, d.nodePorts(), moveForRebalance)
+	if outcome, err := d.applyTxn(ctx, txn); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("moving device %s is %s: %v", dev.devGID, outcome, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const reclaimCheckPeriod time.Duration = 30 * time.Second // time between two scans for idle GPUs
+
+// reclaimerConfig is read from the reclaim_* and warm_capacity keys of the config file
+type reclaimerConfig struct {
+	idle        time.Duration  // time a GPU stays free before it is detached, the reclaimer is disabled if zero
+	defaultWarm int            // free GPUs kept on every node
+	warm        map[string]int // node name to the free GPUs kept on it, overrides defaultWarm
+}
+
+// Returns the free GPUs which are kept attached to the node
+func (cfg reclaimerConfig) warmCapacity(nodeName string) int {
+	if warm, ok := cfg.warm[nodeName]; ok {
+		return warm
+	}
+	return cfg.defaultWarm
+}
+
+// Parses the warm capacity, which is either a count for every node or node:count pairs separated by commas
+func parseWarmCapacity(value string) (int, map[string]int, error) {
+	warm := make(map[string]int)
+	if value == "" {
+		return 0, warm, nil
+	}
+	if count, err := strconv.Atoi(value); err == nil {
+		if count < 0 {
+			return 0, nil, fmt.Errorf("invalid warm capacity %d", count)
+		}
+		return count, warm, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return 0, nil, fmt.Errorf("invalid warm capacity entry %q, expected node:count", pair)
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count < 0 {
+			return 0, nil, fmt.Errorf("invalid warm capacity %q of node %s", parts[1], parts[0])
+		}
+		warm[parts[0]] = count
+	}
+	return 0, warm, nil
+}
+
+// idleTracker records since when each attached GPU has been owned by no pod
+type idleTracker struct {
+	sync.Mutex
+	since map[string]time.Time // DevID to the time it was first seen free
+}
+
+// Updates the tracker with the free devices and returns how long each of them has been free
+func (t *idleTracker) observe(free sets.Set[string], lastMoved map[string]time.Time) map[string]time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	for dev := range t.since {
+		if !free.Has(dev) {
+			delete(t.since, dev)
+		}
+	}
+	idle := make(map[string]time.Duration, free.Len())
+	for dev := range free {
+		if _, ok := t.since[dev]; !ok {
+			t.since[dev] = now
+		}
+		// A device which was just moved is not idle, it was moved for a pod about to start
+		if lastMoved[dev].After(t.since[dev]) {
+			t.since[dev] = lastMoved[dev]
+		}
+		idle[dev] = now.Sub(t.since[dev])
+	}
+	return idle
+}
+
+// Detaches the GPUs which have been free for too long into the unassigned state, keeping the warm capacity of every node
+func (d *ReconfigDaemon) runReclaimer(cfg reclaimerConfig, stopCh <-chan struct{}) {
+	klog.InfoS("Reclaiming idle GPUs", "idle", cfg.idle, "warmCapacity", cfg.defaultWarm, "overrides", cfg.warm)
+	tracker := &idleTracker{since: make(map[string]time.Time)}
+	ticker := time.NewTicker(reclaimCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Requests pull GPUs, so the reclaimer never competes with them
+			if d.requests.Len() > 0 {
+				continue
+			}
+			d.reclaim(cfg, tracker)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (d *ReconfigDaemon) reclaim(cfg reclaimerConfig, tracker *idleTracker) {
+	if err := d.updateDevice(); err != nil {
+		klog.ErrorS(err, "Failed to update devices")
+		return
+	}
+
+	// GPUs owned by pods, and every GPU of the nodes whose pods' devices are unknown yet or which pods in Permit
+	// or binding are assumed on, are never touched
+	usedGPUs := d.usedDevices()
+	unsafePorts := sets.Set[string]{}
+	for _, nodeName := range d.pods.notReadyNodes("", "") {
+		if port, ok := d.nodePorts()[nodeName]; ok {
+			unsafePorts.Insert(port)
+		}
+	}
+	unsafePorts = unsafePorts.Union(d.closedPorts())
+
+	free := sets.New[string]()
+	freeOnPort := make(map[string][]string)
+	for dev, port := range d.getDeviceAlloc() {
+		if port == "" || usedGPUs.Has(dev) {
+			continue
+		}
+		free.Insert(dev)
+		freeOnPort[port] = append(freeOnPort[port], dev)
+	}
+	idle := tracker.observe(free, d.getLastMoved())
+
+	reclaimed := 0
+	for nodeName, port := range d.nodePorts() {
+		if unsafePorts.Has(port) {
+			continue
+		}
+		devs := freeOnPort[port]
+		// Reclaims the devices idle for the longest time first
+		sort.Slice(devs, func(i, j int) bool {
+			if idle[devs[i]] != idle[devs[j]] {
+				return idle[devs[i]] > idle[devs[j]]
+			}
+			return devs[i] < devs[j]
+		})
+		keep := cfg.warmCapacity(nodeName)
+		for i := 0; i < len(devs)-keep; i++ {
+			if idle[devs[i]] < cfg.idle {
+				break
+			}
+			if d.dryRun {
+				klog.InfoS("Dry run, the device would be reclaimed", "node", nodeName, "devid", devs[i], "hostport", port, "idle", idle[devs[i]].Round(time.Second))
+				continue
+			}
+			if err := d.reclaimDevice(devs[i], port, moveForReclaim); err != nil {
+				klog.ErrorS(err, "Failed to reclaim the device", "node", nodeName, "devid", devs[i], "hostport", port)
+				continue
+			}
+			klog.InfoS("Reclaimed the device", "node", nodeName, "devid", devs[i], "hostport", port, "idle", idle[devs[i]].Round(time.Second))
+			reclaimed++
+		}
+	}
+
+	if reclaimed > 0 {
+		if err := d.updateDevice(); err != nil {
+			klog.ErrorS(err, "Failed to update devices")
+		}
+	}
+}
+
+// Detaches the device from its host port unless it is being reconfigured
+func (d *ReconfigDaemon) reclaimDevice(devGID string, hostPort string, reason string) error {
+	d.inflight.Lock()
+	if d.inflight.devices.Has(devGID) || d.inflight.targets.Has(hostPort) {
+		d.inflight.Unlock()
+		return fmt.Errorf("device %s or port %s is being reconfigured", devGID, hostPort)
+	}
+	d.inflight.devices.Insert(devGID)
+	d.inflight.donors.Insert(hostPort)
+	d.inflight.Unlock()
+	defer d.inflight.release(sets.Set[string]{}, []gpuOption{{devGID: devGID, hostPort: hostPort}})
+
+	// The device may have been taken by a pod since the scan
+	if d.usedDevices().Has(devGID) {
+		return fmt.Errorf("device %s is used by a pod", devGID)
+	}
+	ctx, span := tracer.Start(context.Background(), "ReconfigDaemon.reclaimDevice",
+		trace.WithAttributes(attribute.String("devid", devGID), attribute.String("hostport", hostPort), attribute.String("reason", reason)))
+	defer span.End()
+	ctx = klog.NewContext(ctx, klog.LoggerWithValues(klog.Background(), "requestID", requestID(ctx), "reason", reason))
+	if err := d.unassign(ctx, devGID); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	devicesMoved.WithLabelValues(reason).Inc()
+	return nil
+}
+
+// Publishes the number of unassigned GPUs for the scheduler, in total and by pool, since an unassigned GPU can only
+// be attached to the host ports of its pool
+func (d *ReconfigDaemon) publishUnassigned(count int, pools map[string]int) {
+	poolsJSON, err := json.Marshal(pools)
+	if err != nil {
+		klog.ErrorS(err, "Failed to marshal the unassigned GPUs by pool", "pools", pools)
+		return
+	}
+	data := map[string]string{"unassigned": strconv.Itoa(count), "unassigned_pools": string(poolsJSON)}
+
+	d.unassignedLock.Lock()
+	defer d.unassignedLock.Unlock()
+	if reflect.DeepEqual(d.unassignedPublished, data) {
+		return
+	}
+
+	cms := d.clientset.CoreV1().ConfigMaps(d.unassignedNamespace)
+	cm, err := cms.Get(context.TODO(), d.unassignedConfigMap, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = cms.Create(context.TODO(), &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: d.unassignedConfigMap, Namespace: d.unassignedNamespace},
+			Data:       data,
+		}, metav1.CreateOptions{})
+	case err == nil:
+		cm.Data = data
+		_, err = cms.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		klog.ErrorS(err, "Failed to publish the unassigned GPUs", "count", count, "pools", pools)
+		return
+	}
+	klog.V(2).InfoS("Published the unassigned GPUs", "count", count, "pools", pools)
+	d.unassignedPublished = data
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"reconfig-daemon/pkg/strategy"
+)
+
+// inflightSet records the devices and host ports used by the reconfigurations in progress,
+// so that parallel reconfigurations never move the same device or take GPUs from a port another one is filling.
+type inflightSet struct {
+	sync.Mutex
+	devices sets.Set[string] // devices being moved
+	targets sets.Set[string] // host ports receiving devices
+	donors  sets.Set[string] // host ports giving devices
+}
+
+func newInflightSet() *inflightSet {
+	return &inflightSet{
+		devices: sets.New[string](),
+		targets: sets.New[string](),
+		donors:  sets.New[string](),
+	}
+}
+
+// Reserves the target ports, fails if any of them is already a target or a donor of another reconfiguration
+func (in *inflightSet) acquireTargets(ports sets.Set[string]) bool {
+	in.Lock()
+	defer in.Unlock()
+	if in.targets.HasAny(ports.UnsortedList()...) || in.donors.HasAny(ports.UnsortedList()...) {
+		return false
+	}
+	in.targets.Insert(ports.UnsortedList()...)
+	return true
+}
+
+func (in *inflightSet) release(targets sets.Set[string], devices []gpuOption) {
+	in.Lock()
+	defer in.Unlock()
+	in.targets.Delete(targets.UnsortedList()...)
+	for _, dev := range devices {
+		in.devices.Delete(dev.devGID)
+		in.donors.Delete(dev.hostPort)
+	}
+}
+
+// gpuOption is a device chosen to be moved to a target node
+type gpuOption struct {
+	devGID     string
+	hostPort   string
+	targetNode string
+}
+
+// Reconfigures several nodes at once, plan maps each target node to its GPU demand.
+// The plan is applied as a whole or rolled back, so the returned demand is either empty or the whole plan.
+// The outcome is empty if nothing was moved.
+func (d *ReconfigDaemon) reconfigPlan(ctx context.Context, plan map[string]int, podName string, podNamespace string) (map[string]int, string, error) {
+	logger := klog.FromContext(ctx)
+	logger.Info("Reconfiguring nodes", "plan", plan)
+	d.activity.touch()
+
+	// GPUs connected to any target node are never taken away
+	targetPorts := sets.Set[string]{}
+	for nodeName := range plan {
+		targetPorts.Insert(d.nodePorts()[nodeName])
+	}
+	if closed := d.closedPorts(); closed.HasAny(targetPorts.UnsortedList()...) {
+		logger.Info("Some nodes of the plan are in maintenance or fenced and never receive GPUs", "plan", plan)
+		return plan, "", nil
+	}
+	if !d.inflight.acquireTargets(targetPorts) {
+		logger.V(2).Info("Nodes of the plan are being reconfigured, retry later", "plan", plan)
+		return plan, "", nil
+	}
+
+	if err := d.updateDevice(); err != nil {
+		logger.Error(err, "Failed to update devices")
+		d.inflight.release(targetPorts, nil)
+		return plan, "", nil
+	}
+
+	d.inflight.Lock()
+	optionGPUs, satisfied := d.selectDevices(plan, targetPorts, podName, podNamespace, d.strategy)
+	for _, dev := range optionGPUs {
+		d.inflight.devices.Insert(dev.devGID)
+		d.inflight.donors.Insert(dev.hostPort)
+	}
+	d.inflight.Unlock()
+	defer d.inflight.release(targetPorts, optionGPUs)
+
+	if d.dryRun {
+		moves := d.planMoves(optionGPUs)
+		logger.Info("Dry run, the plan would be reconfigured", "plan", plan, "moves", describeMoves(moves))
+		d.dryRuns.add(planPreview{
+			Pod:       podNamespace + "/" + podName,
+			Time:      time.Now(),
+			Strategy:  d.strategy.Name(),
+			Plan:      plan,
+			Satisfied: satisfied,
+			Moves:     moves,
+		})
+		if !satisfied {
+			return plan, "", nil
+		}
+		return map[string]int{}, outcomeDryRun, nil
+	}
+
+	// Moves nothing unless the whole plan can be satisfied, a half-satisfied demand only strands GPUs
+	if !satisfied {
+		return plan, "", nil
+	}
+
+	// Performs reconfiguration
+	txn := newReconfigTxn(optionGPUs, d.nodePorts(), moveForRequest)
+	outcome, err := d.applyTxn(ctx, txn)
+	if err != nil {
+		if stranded := txn.stranded(); len(stranded) > 0 {
+			err = fmt.Errorf("%v, devices %v could not be rolled back", err, stranded)
+		}
+		return plan, outcome, err
+	}
+	return map[string]int{}, outcome, nil
+}
+
+// Chooses the devices to move to the target nodes of the plan with the strategy, the inflight set must be locked.
+// Returns false if some target node cannot be satisfied.
+func (d *ReconfigDaemon) selectDevices(plan map[string]int, targetPorts sets.Set[string], podName string, podNamespace string, strat strategy.SelectionStrategy) ([]gpuOption, bool) {
+	// Devices of the pods which are not running yet are unknown, and the pods in Permit or binding are about to
+	// take the free GPUs of their nodes, so these nodes cannot give GPUs
+	usedGPUs := d.usedDevices()
+	unsafePorts := sets.Set[string]{}
+	for _, nodeName := range d.pods.notReadyNodes(podName, podNamespace) {
+		if port, ok := d.nodePorts()[nodeName]; ok {
+			unsafePorts.Insert(port)
+		}
+	}
+
+	podsOnPort := make(map[string]int)
+	for nodeName, count := range d.pods.podsPerNode() {
+		podsOnPort[d.nodePorts()[nodeName]] += count
+	}
+	lastMoved := d.getLastMoved()
+
+	nodeNames := make([]string, 0, len(plan))
+	for nodeName := range plan {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+
+	var candidates []strategy.Device
+	for dev, nodePort := range d.getDeviceAlloc() {
+		// candidates are GPUs that are not used, not moving and not connected to the target nodes
+		if usedGPUs.Has(dev) || targetPorts.Has(nodePort) || unsafePorts.Has(nodePort) {
+			continue
+		}
+		if d.inflight.devices.Has(dev) || d.inflight.targets.Has(nodePort) {
+			continue
+		}
+		candidates = append(candidates, strategy.Device{DevID: dev, HostPort: nodePort, LastMoved: lastMoved[dev]})
+	}
+
+	// Unassigned GPUs are pulled first, they cost a single step and take nothing from any host
+	var unattached, attached []strategy.Device
+	for _, dev := range candidates {
+		if dev.HostPort == "" {
+			unattached = append(unattached, dev)
+		} else {
+			attached = append(attached, dev)
+		}
+	}
+
+	// A GPU can only be moved within its pool, like the scheduler counts the reachable GPUs
+	var optionGPUs []gpuOption
+	satisfied := true
+	portPools := d.portPools()
+	devicePools := d.devicePools(portPools)
+	for _, nodeName := range nodeNames {
+		targetPort := d.nodePorts()[nodeName]
+		req := strategy.Request{
+			TargetPort: targetPort,
+			Demand:     plan[nodeName],
+			Candidates: inPool(unattached, devicePools, portPools[targetPort]),
+			Switches:   d.switches(),
+			PodsOnPort: podsOnPort,
+		}
+		selected := strat.Select(req)
+		if len(selected) < plan[nodeName] {
+			req.Demand = plan[nodeName] - len(selected)
+			req.Candidates = inPool(attached, devicePools, portPools[targetPort])
+			selected = append(selected, strat.Select(req)...)
+		}
+		if len(selected) < plan[nodeName] {
+			klog.V(2).InfoS("Node lacks more GPUs than can be moved", "node", nodeName, "demand", plan[nodeName], "movable", len(selected))
+			satisfied = false
+		}
+		chosen := sets.New[string]()
+		for _, dev := range selected {
+			chosen.Insert(dev.DevID)
+			optionGPUs = append(optionGPUs, gpuOption{devGID: dev.DevID, hostPort: dev.HostPort, targetNode: nodeName})
+		}
+		unattached = withoutDevices(unattached, chosen)
+		attached = withoutDevices(attached, chosen)
+	}
+	return optionGPUs, satisfied
+}
+
+// Returns the devices of the pool
+func inPool(devs []strategy.Device, devicePools map[string]string, pool string) []strategy.Device {
+	var reachable []strategy.Device
+	for _, dev := range devs {
+		if devicePools[dev.DevID] == pool {
+			reachable = append(reachable, dev)
+		}
+	}
+	return reachable
+}
+
+func withoutDevices(devs []strategy.Device, excluded sets.Set[string]) []strategy.Device {
+	remaining := devs[:0:0]
+	for _, dev := range devs {
+		if !excluded.Has(dev.DevID) {
+			remaining = append(remaining, dev)
+		}
+	}
+	return remaining
+}
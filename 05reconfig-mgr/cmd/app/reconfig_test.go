@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"kubecomp/topology"
+	"reconfig-daemon/pkg/strategy"
+)
+
+// A reclaimed GPU keeps the pool of the host port it was detached from, and is only moved within that pool
+func TestSelectDevicesWithinPool(t *testing.T) {
+	topo, err := topology.Parse([]byte(`
+apiVersion: kubecomp/v1alpha1
+kind: Topology
+pools:
+- name: pool1
+  hostPorts:
+  - {port: A, node: a}
+  - {port: T, node: n1}
+  devices: [u1]
+- name: pool2
+  hostPorts:
+  - {port: B, node: b}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := &fakePool{alloc: map[string]string{"u1": "", "a1": "A", "b1": "B", "b2": "B"}}
+	d := &ReconfigDaemon{
+		clientset:           fake.NewSimpleClientset(),
+		devIF:               pool,
+		lastMoved:           make(map[string]time.Time),
+		lastPools:           make(map[string]string),
+		pods:                newPodIndex(nil),
+		reserved:            &reservedDevices{devices: sets.New[string]()},
+		inflight:            newInflightSet(),
+		unassignedNamespace: "kubecomp",
+		unassignedConfigMap: "pool-unassigned",
+	}
+	d.setTopology(topo)
+	if err := d.updateDevice(); err != nil {
+		t.Fatal(err)
+	}
+
+	// b2 is reclaimed from pool2, u1 is listed in pool1 by the topology
+	pool.alloc["b2"] = ""
+	if err := d.updateDevice(); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := d.clientset.CoreV1().ConfigMaps("kubecomp").Get(context.Background(), "pool-unassigned", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.Data["unassigned"] != "2" || cm.Data["unassigned_pools"] != `{"pool1":1,"pool2":1}` {
+		t.Errorf("published %v, want 2 unassigned GPUs, one in each pool", cm.Data)
+	}
+
+	strat, err := strategy.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, satisfied := d.selectDevices(map[string]int{"n1": 3}, sets.New("T"), "p", "default", strat)
+	var selected []string
+	for _, option := range options {
+		selected = append(selected, option.devGID)
+	}
+	sort.Strings(selected)
+	if satisfied || len(selected) != 2 || selected[0] != "a1" || selected[1] != "u1" {
+		t.Errorf("selectDevices() = %v, %v, want [a1 u1] of pool1 and the demand unsatisfied", selected, satisfied)
+	}
+}
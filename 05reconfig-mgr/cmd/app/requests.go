@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// Backoff between two attempts of the requests of a node key
+const (
+	requestRetryBaseDelay time.Duration = 100 * time.Millisecond
+	requestRetryMaxDelay  time.Duration = 2 * time.Second
+)
+
+// Time the scheduler waits in Permit for the reconfiguration, 15 seconds and 5 more per GPU like reconfigWaitTime
+// of the scheduler. The pod is then rejected and annotated again on its next attempt.
+func permitWaitTime(plan map[string]int) time.Duration {
+	demand := 0
+	for _, count := range plan {
+		demand += count
+	}
+	return time.Duration(15+5*demand) * time.Second
+}
+
+// reconfigRequest is the GPU demand of a pending pod
+type reconfigRequest struct {
+	name      string
+	namespace string
+	plan      map[string]int // target node to the GPUs still missing
+	deadline  time.Time      // the request is retried until the scheduler stops waiting for it in Permit
+}
+
+// requestTable keeps the pending requests by the key of their target nodes
+type requestTable struct {
+	sync.Mutex
+	requests map[string]map[string]*reconfigRequest // node key to pod key to request
+}
+
+func newRequestTable() *requestTable {
+	return &requestTable{
+		requests: make(map[string]map[string]*reconfigRequest),
+	}
+}
+
+func (t *requestTable) put(nodeKey string, req *reconfigRequest) {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.requests[nodeKey]; !ok {
+		t.requests[nodeKey] = make(map[string]*reconfigRequest)
+	}
+	t.requests[nodeKey][req.namespace+"/"+req.name] = req
+}
+
+// Puts back a request which is retried, unless a newer request of the same pod was queued meanwhile
+func (t *requestTable) requeue(nodeKey string, req *reconfigRequest) {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.requests[nodeKey]; !ok {
+		t.requests[nodeKey] = make(map[string]*reconfigRequest)
+	}
+	if _, ok := t.requests[nodeKey][req.namespace+"/"+req.name]; !ok {
+		t.requests[nodeKey][req.namespace+"/"+req.name] = req
+	}
+}
+
+// Returns a copy of the pending requests
+func (t *requestTable) list() []reconfigRequest {
+	t.Lock()
+	defer t.Unlock()
+	var reqs []reconfigRequest
+	for _, byPod := range t.requests {
+		for _, req := range byPod {
+			reqs = append(reqs, *req)
+		}
+	}
+	return reqs
+}
+
+// Takes away all requests of the node key
+func (t *requestTable) take(nodeKey string) []*reconfigRequest {
+	t.Lock()
+	defer t.Unlock()
+	var reqs []*reconfigRequest
+	for _, req := range t.requests[nodeKey] {
+		reqs = append(reqs, req)
+	}
+	delete(t.requests, nodeKey)
+	return reqs
+}
+
+// Returns the queue key of the plan, which is the sorted names of its target nodes
+func planKey(plan map[string]int) string {
+	nodeNames := make([]string, 0, len(plan))
+	for nodeName := range plan {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+	return strings.Join(nodeNames, ",")
+}
+
+// Annotations of the scheduler carrying a reconfiguration request, reconfig_attempt changes on every attempt in Permit
+var requestAnnotations = []string{"dst_node", "gpu_demand", "gang_plan", "reconfig_attempt"}
+
+// Returns the pod event handlers which enqueue the requests of the pending pods, when the pods are listed,
+// e.g. after a restart, and whenever the scheduler annotates them in Permit
+func (d *ReconfigDaemon) reconfigRequestHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if po, ok := obj.(*v1.Pod); ok && hasRequest(po) {
+				d.enqueueRequest(po)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPo, ok := oldObj.(*v1.Pod)
+			if !ok {
+				return
+			}
+			po, ok := newObj.(*v1.Pod)
+			if !ok || !hasRequest(po) {
+				return
+			}
+			for _, key := range requestAnnotations {
+				if oldPo.Annotations[key] != po.Annotations[key] {
+					d.enqueueRequest(po)
+					return
+				}
+			}
+		},
+	}
+}
+
+// Returns whether the pod is pending with a reconfiguration request
+func hasRequest(po *v1.Pod) bool {
+	if po.Status.Phase != v1.PodPending {
+		return false
+	}
+	_, hasDemand := po.Annotations["gpu_demand"]
+	_, hasNode := po.Annotations["dst_node"]
+	_, hasPlan := po.Annotations["gang_plan"]
+	return (hasDemand && hasNode) || hasPlan
+}
+
+// Reads the demand of the pod and queues it under its target nodes
+func (d *ReconfigDaemon) enqueueRequest(po *v1.Pod) {
+	plan, err := getPodPlan(po)
+	if err != nil {
+		klog.ErrorS(err, "Invalid reconfiguration request", "pod", klog.KObj(po))
+		return
+	}
+
+	klog.InfoS("Reconfig request detected", "pod", klog.KObj(po), "requestID", requestID(podTraceContext(po.Annotations)), "plan", plan)
+	key := planKey(plan)
+	d.pending.put(key, &reconfigRequest{name: po.Name, namespace: po.Namespace, plan: plan, deadline: time.Now().Add(permitWaitTime(plan))})
+	d.requests.Add(key)
+	reconfigRequests.Inc()
+}
+
+// Returns the plan of the pod, a pod group carries one plan for all of its members
+func getPodPlan(po *v1.Pod) (map[string]int, error) {
+	if gangPlan := po.Annotations["gang_plan"]; gangPlan != "" {
+		var plan map[string]int
+		if err := json.Unmarshal([]byte(gangPlan), &plan); err != nil {
+			return nil, fmt.Errorf("invalid gang plan: %v", err)
+		}
+		return plan, nil
+	}
+
+	nodeName := po.Annotations["dst_node"]
+	demandCnt, err := strconv.Atoi(po.Annotations["gpu_demand"])
+	if err != nil || nodeName == "" {
+		return nil, fmt.Errorf("invalid GPU demand %q on node %q", po.Annotations["gpu_demand"], nodeName)
+	}
+	return map[string]int{nodeName: demandCnt}, nil
+}
+
+// Handles the requests of one node key at a time, several workers handle different nodes in parallel
+func (d *ReconfigDaemon) runReconfigWorker() {
+	for d.processNextNode() {
+	}
+}
+
+func (d *ReconfigDaemon) processNextNode() bool {
+	key, quit := d.requests.Get()
+	if quit {
+		return false
+	}
+	defer d.requests.Done(key)
+
+	// A target being reconfigured, an unsafe donor or a demand not satisfied yet is retried while the pod waits in Permit
+	nodeKey := key.(string)
+	retry := false
+	for _, req := range d.pending.take(nodeKey) {
+		if d.handleReconfigRequest(req) {
+			continue
+		}
+		if time.Now().After(req.deadline) {
+			klog.InfoS("Dropping the request, the scheduler stopped waiting for it", "pod", klog.KRef(req.namespace, req.name), "nodes", nodeKey, "retries", d.requests.NumRequeues(key))
+			d.reportOutcome(req.name, req.namespace, outcomeAbandoned,
+				fmt.Sprintf("Gave up moving GPUs to nodes %v once the scheduler stopped waiting in Permit, the next attempt requests them again", req.plan))
+			continue
+		}
+		d.pending.requeue(nodeKey, req)
+		retry = true
+	}
+
+	if !retry {
+		d.requests.Forget(key)
+		return true
+	}
+	d.requests.AddRateLimited(key)
+	return true
+}
+
+// Returns true when the request is finished, false when it should be retried
+func (d *ReconfigDaemon) handleReconfigRequest(req *reconfigRequest) bool {
+	curPod, err := d.podLister.Pods(req.namespace).Get(req.name)
+	if err != nil || curPod.Status.Phase != v1.PodPending {
+		// skip if the pod does not exist or is already scheduled
+		return true
+	}
+
+	// The span joins the trace the scheduler started in Permit
+	ctx, span := tracer.Start(podTraceContext(curPod.Annotations), "ReconfigDaemon.reconfig", podAttributes(req.name, req.namespace))
+	defer span.End()
+	logger := klog.LoggerWithValues(klog.Background(), "requestID", requestID(ctx), "pod", klog.KObj(curPod))
+	ctx = klog.NewContext(ctx, logger)
+	span.SetAttributes(attribute.String("plan", fmt.Sprint(req.plan)))
+
+	remaining, outcome, err := d.reconfigPlan(ctx, req.plan, req.name, req.namespace)
+	span.SetAttributes(attribute.String("outcome", outcome))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	switch outcome {
+	case outcomeSucceeded:
+		d.reportOutcome(req.name, req.namespace, outcome, fmt.Sprintf("Moved GPUs to nodes as planned: %v", req.plan))
+	case outcomeDryRun:
+		d.reportOutcome(req.name, req.namespace, outcome, fmt.Sprintf("Dry run, GPUs are not moved for plan %v, the moves are listed by GET /plans of reconfig-mgr", req.plan))
+	case outcomeRolledBack, outcomeFailed:
+		logger.Error(err, "Reconfiguration failed", "outcome", outcome)
+		d.reportOutcome(req.name, req.namespace, outcome, fmt.Sprintf("Reconfiguration %v is %s, it will be retried: %v", req.plan, outcome, err))
+	}
+	if len(remaining) > 0 {
+		logger.Info("Failed to satisfy the GPU demand", "remaining", remaining)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// A request whose target is being reconfigured is retried while the scheduler waits in Permit, then abandoned
+func TestProcessNextNodeRetriesConflicts(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", Annotations: map[string]string{"dst_node": "n1", "gpu_demand": "2"}},
+		Status:     v1.PodStatus{Phase: v1.PodPending},
+	}
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := podIndexer.Add(pod); err != nil {
+		t.Fatal(err)
+	}
+	clientset := fake.NewSimpleClientset(pod)
+	d := &ReconfigDaemon{
+		clientset:      clientset,
+		podLister:      corelisters.NewPodLister(podIndexer),
+		nodeLister:     corelisters.NewNodeLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		nodeNameToPort: map[string]string{"n1": "T"},
+		requests:       workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)),
+		pending:        newRequestTable(),
+		inflight:       newInflightSet(),
+		activity:       &activityTracker{},
+		recorder:       record.NewFakeRecorder(10),
+	}
+	// Another reconfiguration fills the target port
+	if !d.inflight.acquireTargets(sets.New("T")) {
+		t.Fatal("failed to acquire the target port")
+	}
+
+	d.enqueueRequest(pod)
+	for i := 0; i < 20; i++ {
+		d.processNextNode()
+	}
+	if reqs := d.pending.list(); len(reqs) != 1 {
+		t.Fatalf("pending requests = %v after 20 conflicts within the Permit wait, want the request kept", reqs)
+	}
+	if d.requests.NumRequeues("n1") != 20 {
+		t.Errorf("NumRequeues() = %d, want 20", d.requests.NumRequeues("n1"))
+	}
+	po, err := clientset.CoreV1().Pods("default").Get(context.Background(), "p", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := po.Annotations["reconfig_status"]; status != "" {
+		t.Errorf("reconfig_status = %q while the scheduler waits, want none", status)
+	}
+
+	// The scheduler stopped waiting, the request is dropped until the next attempt
+	d.pending.requests["n1"]["default/p"].deadline = time.Now().Add(-time.Second)
+	d.processNextNode()
+	if reqs := d.pending.list(); len(reqs) != 0 {
+		t.Errorf("pending requests = %v after the Permit wait, want none", reqs)
+	}
+	po, err = clientset.CoreV1().Pods("default").Get(context.Background(), "p", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := po.Annotations["reconfig_status"]; status != outcomeAbandoned {
+		t.Errorf("reconfig_status = %q after the Permit wait, want %s", status, outcomeAbandoned)
+	}
+}
+
+func TestPermitWaitTime(t *testing.T) {
+	if wait := permitWaitTime(map[string]int{"n1": 2, "n2": 1}); wait != 30*time.Second {
+		t.Errorf("permitWaitTime() = %v, want 30s like the scheduler for 3 GPUs", wait)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// reservedDevices tracks the devices reserved by the DRA driver for its ResourceClaims.
+// The driver publishes them in a ConfigMap, keyed by DevID, so that reconfig-mgr never moves nor reclaims them.
+type reservedDevices struct {
+	sync.RWMutex
+	devices sets.Set[string]
+}
+
+func (r *reservedDevices) update(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		return
+	}
+	devices := sets.New[string]()
+	for dev := range cm.Data {
+		devices.Insert(dev)
+	}
+	r.set(devices)
+	klog.V(2).InfoS("Devices reserved by the DRA driver", "configMap", klog.KObj(cm), "devids", sets.List(devices))
+}
+
+func (r *reservedDevices) set(devices sets.Set[string]) {
+	r.Lock()
+	defer r.Unlock()
+	r.devices = devices
+}
+
+// Returns the reserved devices, which must not be modified
+func (r *reservedDevices) get() sets.Set[string] {
+	r.RLock()
+	defer r.RUnlock()
+	return r.devices
+}
+
+// Watches the ConfigMap of the reserved devices, the devices are released when it is deleted
+func (d *ReconfigDaemon) watchReserved(namespace string, name string, stopCh <-chan struct{}) {
+	factory := informers.NewSharedInformerFactoryWithOptions(d.clientset, 0, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	_, err := factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    d.reserved.update,
+		UpdateFunc: func(_, obj interface{}) { d.reserved.update(obj) },
+		DeleteFunc: func(interface{}) { d.reserved.set(sets.New[string]()) },
+	})
+	if err != nil {
+		klog.ErrorS(err, "Failed to watch the devices reserved by the DRA driver", "configMap", klog.KRef(namespace, name))
+		return
+	}
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+}
+
+// Returns the devices owned by the pods or reserved by the DRA driver
+func (d *ReconfigDaemon) usedDevices() sets.Set[string] {
+	return d.pods.usedDevices().Union(d.reserved.get()).Union(d.unresolvedDevices())
+}
+
+// Returns every device attached to the nodes of the pods whose devices are not known yet, which they may own
+func (d *ReconfigDaemon) unresolvedDevices() sets.Set[string] {
+	ports := sets.New[string]()
+	nodePorts := d.nodePorts()
+	for nodeName := range d.pods.unresolvedNodes() {
+		if port, ok := nodePorts[nodeName]; ok {
+			ports.Insert(port)
+		}
+	}
+	devices := sets.New[string]()
+	if ports.Len() == 0 {
+		return devices
+	}
+	for dev, port := range d.getDeviceAlloc() {
+		if ports.Has(port) {
+			devices.Insert(dev)
+		}
+	}
+	return devices
+}
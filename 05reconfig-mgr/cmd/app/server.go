@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"reconfig-daemon/pkg/strategy"
+)
+
+const maxDryRunRecords int = 100 // dry-run plans kept for GET /plans
+
+// move is a step of a plan as shown to operators
+type move struct {
+	DevID    string `json:"devid"`
+	FromPort string `json:"from_port"` // empty if the device is not attached to any host
+	ToPort   string `json:"to_port"`
+	Node     string `json:"node"`
+}
+
+// planPreview is the plan computed against the live state, without moving anything
+type planPreview struct {
+	Pod       string         `json:"pod,omitempty"`
+	Time      time.Time      `json:"time"`
+	Strategy  string         `json:"strategy"`
+	Plan      map[string]int `json:"plan"`
+	Satisfied bool           `json:"satisfied"`
+	Moves     []move         `json:"moves"`
+}
+
+// dryRunRecords keeps the most recent plans computed in dry-run mode
+type dryRunRecords struct {
+	sync.Mutex
+	plans []planPreview
+}
+
+func (r *dryRunRecords) add(preview planPreview) {
+	r.Lock()
+	defer r.Unlock()
+	r.plans = append(r.plans, preview)
+	if len(r.plans) > maxDryRunRecords {
+		r.plans = r.plans[len(r.plans)-maxDryRunRecords:]
+	}
+}
+
+func (r *dryRunRecords) list() []planPreview {
+	r.Lock()
+	defer r.Unlock()
+	plans := make([]planPreview, len(r.plans))
+	copy(plans, r.plans)
+	return plans
+}
+
+func (d *ReconfigDaemon) planMoves(options []gpuOption) []move {
+	moves := make([]move, 0, len(options))
+	for _, dev := range options {
+		moves = append(moves, move{DevID: dev.devGID, FromPort: dev.hostPort, ToPort: d.nodePorts()[dev.targetNode], Node: dev.targetNode})
+	}
+	return moves
+}
+
+func describeMoves(moves []move) string {
+	if len(moves) == 0 {
+		return "no move"
+	}
+	steps := make([]string, 0, len(moves))
+	for _, m := range moves {
+		from := m.FromPort
+		if from == "" {
+			from = "unattached"
+		}
+		steps = append(steps, fmt.Sprintf("device %s from port %s to port %s (%s)", m.DevID, from, m.ToPort, m.Node))
+	}
+	return strings.Join(steps, ", ")
+}
+
+// Parses a plan written as node:demand pairs separated by commas, e.g. kind-worker:2,kind-worker2:1
+func parsePlan(value string) (map[string]int, error) {
+	plan := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid plan entry %q, expected node:demand", pair)
+		}
+		demand, err := strconv.Atoi(parts[1])
+		if err != nil || demand < 1 {
+			return nil, fmt.Errorf("invalid demand %q of node %s", parts[1], parts[0])
+		}
+		plan[parts[0]] = demand
+	}
+	return plan, nil
+}
+
+// Computes the plan against the live state with the given strategy, nothing is reserved or moved
+func (d *ReconfigDaemon) previewPlan(plan map[string]int, strat strategy.SelectionStrategy) (planPreview, error) {
+	targetPorts := sets.Set[string]{}
+	for nodeName := range plan {
+		port, ok := d.nodePorts()[nodeName]
+		if !ok {
+			return planPreview{}, fmt.Errorf("unknown node %s", nodeName)
+		}
+		targetPorts.Insert(port)
+	}
+	if err := d.updateDevice(); err != nil {
+		return planPreview{}, fmt.Errorf("failed to update devices: %v", err)
+	}
+
+	d.inflight.Lock()
+	optionGPUs, satisfied := d.selectDevices(plan, targetPorts, "", "", strat)
+	d.inflight.Unlock()
+
+	return planPreview{
+		Time:      time.Now(),
+		Strategy:  strat.Name(),
+		Plan:      plan,
+		Satisfied: satisfied,
+		Moves:     d.planMoves(optionGPUs),
+	}, nil
+}
+
+// Handles the GET /plan?plan=node:demand,...&strategy=name request
+func (d *ReconfigDaemon) getPlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	plan, err := parsePlan(r.URL.Query().Get("plan"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	strat := d.strategy
+	if name := r.URL.Query().Get("strategy"); name != "" {
+		if strat, err = strategy.New(name); err != nil {
+			http.Error(w, fmt.Sprintf("%v, available: %s", err, strings.Join(strategy.Names(), ", ")), http.StatusBadRequest)
+			return
+		}
+	}
+
+	preview, err := d.previewPlan(plan, strat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, preview)
+}
+
+// Handles the GET /plans request, which lists the plans computed in dry-run mode
+func (d *ReconfigDaemon) getDryRunPlans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, d.dryRuns.list())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		klog.ErrorS(err, "Error encoding response")
+	}
+}
+
+// deviceStatus is a device of the pool as shown to operators
+type deviceStatus struct {
+	DevID    string `json:"devid"`
+	HostPort string `json:"hostport"` // empty if the device is not attached to any host
+	Node     string `json:"node,omitempty"`
+	Pod      string `json:"pod,omitempty"` // namespace/name of the pod owning the device
+	Reserved bool   `json:"reserved"`      // reserved by the DRA driver for a ResourceClaim
+	Moving   bool   `json:"moving"`        // being reconfigured
+}
+
+// Handles the GET /devices request, which lists the devices of the pool with their node and owner
+func (d *ReconfigDaemon) getDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := d.updateDevice(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to update devices: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	portToNode := make(map[string]string, len(d.nodePorts()))
+	for nodeName, port := range d.nodePorts() {
+		portToNode[port] = nodeName
+	}
+	owners := d.pods.owners()
+	reserved := d.reserved.get()
+	d.inflight.Lock()
+	moving := d.inflight.devices.Clone()
+	d.inflight.Unlock()
+
+	devices := make([]deviceStatus, 0, len(d.getDeviceAlloc()))
+	for dev, port := range d.getDeviceAlloc() {
+		devices = append(devices, deviceStatus{
+			DevID:    dev,
+			HostPort: port,
+			Node:     portToNode[port],
+			Pod:      owners[dev],
+			Reserved: reserved.Has(dev),
+			Moving:   moving.Has(dev),
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DevID < devices[j].DevID })
+	writeJSON(w, devices)
+}
+
+// requestStatus is a pending reconfiguration request as shown to operators
+type requestStatus struct {
+	Pod  string         `json:"pod"`
+	Plan map[string]int `json:"plan"` // target node to the GPUs still missing
+}
+
+// Handles the GET /requests request, which lists the requests waiting in the queue
+func (d *ReconfigDaemon) getRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reqs := d.pending.list()
+	requests := make([]requestStatus, 0, len(reqs))
+	for _, req := range reqs {
+		requests = append(requests, requestStatus{Pod: req.namespace + "/" + req.name, Plan: req.plan})
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Pod < requests[j].Pod })
+	writeJSON(w, requests)
+}
+
+// Answers 503 unless this replica is the leader and built its state, the standbys have no state to serve
+func (d *ReconfigDaemon) leaderOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.serving.Load() || !d.isLeader() {
+			http.Error(w, "Not the leader", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Handles the GET /healthz request, which every replica answers so that the standbys are ready as well
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// Returns the plan preview endpoints, the listings and moves of the kubectl plugin and the DRA driver, served by the
+// leader only, and the health, log level and Prometheus metrics of every replica. The moves and the log level are
+// authorized by the API server.
+func (d *ReconfigDaemon) newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/plan", d.leaderOnly(d.getPlan))
+	mux.HandleFunc("/plans", d.leaderOnly(d.getDryRunPlans))
+	mux.HandleFunc("/devices", d.leaderOnly(d.getDevices))
+	mux.HandleFunc("/requests", d.leaderOnly(d.getRequests))
+	mux.HandleFunc("/move", d.leaderOnly(d.postMove))
+	mux.HandleFunc("/healthz", getHealth)
+	mux.HandleFunc("/debug/flags/v", d.requireLogLevelAccess(putLogLevel))
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+func (d *ReconfigDaemon) startServer(addr string) {
+	klog.InfoS("Serving plan previews and metrics", "address", addr)
+	fatal(http.ListenAndServe(addr, d.newServeMux()), "Failed to serve", "address", addr)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A standby is healthy and serves its metrics, but leaves the API to the leader
+func TestStandbyServesHealthOnly(t *testing.T) {
+	d := &ReconfigDaemon{pending: newRequestTable()}
+	mux := d.newServeMux()
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/metrics": http.StatusOK, "/requests": http.StatusServiceUnavailable, "/devices": http.StatusServiceUnavailable} {
+		if code := get(path); code != want {
+			t.Errorf("GET %s on a standby = %d, want %d", path, code, want)
+		}
+	}
+
+	d.serving.Store(true)
+	if code := get("/requests"); code != http.StatusOK {
+		t.Errorf("GET /requests on the leader = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"kubecomp/topology"
+)
+
+// Returns the node name to host port mapping of the current topology, which must not be modified
+func (d *ReconfigDaemon) nodePorts() map[string]string {
+	d.topoLock.RLock()
+	defer d.topoLock.RUnlock()
+	return d.nodeNameToPort
+}
+
+// Returns the host port to switch mapping of the current topology, which must not be modified
+func (d *ReconfigDaemon) switches() map[string]string {
+	d.topoLock.RLock()
+	defer d.topoLock.RUnlock()
+	return d.portSwitches
+}
+
+// Returns the pool of each host port of the topology and of the labeled nodes. The pool of a labeled node is given by its
+// pool label, the nodes missing from the topology default to the default pool.
+func (d *ReconfigDaemon) portPools() map[string]string {
+	portPools := make(map[string]string)
+	d.topoLock.RLock()
+	if d.topo != nil {
+		for _, hp := range d.topo.HostPorts() {
+			portPools[hp.Port] = hp.Pool
+		}
+	}
+	d.topoLock.RUnlock()
+
+	for nodeName, port := range d.nodePorts() {
+		if d.nodeLister != nil {
+			if node, err := d.nodeLister.Get(nodeName); err == nil && node.Labels[topology.PoolLabel] != "" {
+				portPools[port] = node.Labels[topology.PoolLabel]
+			}
+		}
+		if portPools[port] == "" {
+			portPools[port] = topology.DefaultPoolName
+		}
+	}
+	return portPools
+}
+
+// Returns the pool of each device, which is the pool of the host port it is attached to, else the pool listing it in
+// the topology, else the pool of the host port it was last attached to. An unassigned device never seen attached and
+// listed in no pool belongs to the only pool, and is left out if there are several pools.
+func (d *ReconfigDaemon) devicePools(portPools map[string]string) map[string]string {
+	pools := sets.New[string]()
+	for _, pool := range portPools {
+		pools.Insert(pool)
+	}
+	d.topoLock.RLock()
+	configured := make(map[string]string)
+	if d.topo != nil {
+		configured = d.topo.DevicePools()
+		for _, pool := range d.topo.Pools {
+			pools.Insert(pool.Name)
+		}
+	}
+	d.topoLock.RUnlock()
+	onlyPool := topology.DefaultPoolName
+	if pools.Len() == 1 {
+		onlyPool = pools.UnsortedList()[0]
+	}
+
+	d.devLock.RLock()
+	defer d.devLock.RUnlock()
+	devicePools := make(map[string]string, len(d.deviceAlloc))
+	for dev, port := range d.deviceAlloc {
+		switch {
+		case port != "" && portPools[port] != "":
+			devicePools[dev] = portPools[port]
+		case configured[dev] != "":
+			devicePools[dev] = configured[dev]
+		case d.lastPools[dev] != "":
+			devicePools[dev] = d.lastPools[dev]
+		case pools.Len() <= 1:
+			devicePools[dev] = onlyPool
+		}
+	}
+	return devicePools
+}
+
+// Merges the host ports labeled on the nodes by the device plugin into the topology, the labels win.
+// The mapping is replaced rather than modified, so the transactions in flight keep the ports they were planned with.
+// Must be called with topoLock held.
+func (d *ReconfigDaemon) mergeNodePorts() {
+	nodePorts := make(map[string]string)
+	if d.topo != nil {
+		nodePorts = d.topo.NodePorts()
+	}
+	for nodeName, port := range d.labeledPorts {
+		if configured, ok := nodePorts[nodeName]; ok && configured != port {
+			klog.V(2).InfoS("The label of the node overrides the topology", "node", nodeName, "hostport", port, "topologyHostport", configured)
+		}
+		nodePorts[nodeName] = port
+	}
+
+	owners := make(map[string]string, len(nodePorts))
+	for nodeName, port := range nodePorts {
+		if other, ok := owners[port]; ok {
+			klog.ErrorS(nil, "Host port claimed by several nodes, check the topology and the host port labels", "hostport", port, "nodes", []string{other, nodeName})
+		}
+		owners[port] = nodeName
+	}
+	d.nodeNameToPort = nodePorts
+}
+
+// Applies a topology loaded at startup or reloaded from the ConfigMap
+func (d *ReconfigDaemon) setTopology(topo *topology.Topology) {
+	d.topoLock.Lock()
+	old := d.topo
+	d.topo = topo
+	d.mergeNodePorts()
+	d.portSwitches = topo.PortSwitches()
+	d.topoLock.Unlock()
+	d.devIF.SetEndpoints(topo.Endpoints.Resources, topo.Endpoints.Allocation)
+
+	if old == nil {
+		klog.InfoS("Loaded the topology", "version", topo.APIVersion, "pools", len(topo.Pools), "nodes", topo.NodePorts())
+		return
+	}
+	added, recabled, removed := topology.Diff(old, topo)
+	klog.InfoS("Reloaded the topology", "version", topo.APIVersion, "pools", len(topo.Pools), "added", added, "recabled", recabled, "removed", removed)
+	d.topologyChanged(append(added, recabled...)...)
+	if err := d.updateDevice(); err != nil {
+		klog.ErrorS(err, "Failed to update devices")
+	}
+}
+
+// Checks the nodes cabled to a new port for maintenance and failures again, they may have to be drained or fenced there
+func (d *ReconfigDaemon) topologyChanged(nodeNames ...string) {
+	for _, nodeName := range nodeNames {
+		d.drains.Add(nodeName)
+		d.failures.Add(nodeName)
+	}
+}
+
+// Reloads the topology whenever its ConfigMap changes, an invalid version is logged and the current one is kept
+func (d *ReconfigDaemon) watchTopology() {
+	err := topology.Watch(topology.DefaultPath, nil, d.setTopology, func(err error) {
+		klog.ErrorS(err, "Ignoring the topology change")
+	})
+	if err != nil {
+		klog.ErrorS(err, "Failed to watch the topology, changes require a restart", "path", topology.DefaultPath)
+	}
+}
+
+// Tracks the host ports the device plugin labels on the nodes
+func (d *ReconfigDaemon) nodeTopologyEventHandler() cache.ResourceEventHandler {
+	setLabel := func(nodeName string, port string) {
+		d.topoLock.Lock()
+		if d.labeledPorts[nodeName] == port {
+			d.topoLock.Unlock()
+			return
+		}
+		if port == "" {
+			delete(d.labeledPorts, nodeName)
+		} else {
+			d.labeledPorts[nodeName] = port
+		}
+		d.mergeNodePorts()
+		d.topoLock.Unlock()
+
+		klog.InfoS("Host port label changed", "node", nodeName, "hostport", port)
+		d.topologyChanged(nodeName)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*v1.Node); ok {
+				setLabel(node.Name, node.Labels[topology.HostPortLabel])
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if node, ok := obj.(*v1.Node); ok {
+				setLabel(node.Name, node.Labels[topology.HostPortLabel])
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*v1.Node); ok {
+				setLabel(node.Name, "")
+			}
+		},
+	}
+}
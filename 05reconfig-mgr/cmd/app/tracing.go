@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName string = "reconfig-mgr"
+
+var tracer = otel.Tracer(tracerName)
+
+// Exports the spans to the OTLP collector at endpoint (host:port), or drops them if endpoint is empty.
+// The trace context is propagated and the trace IDs are generated in any case, so that the spans of the pool
+// still join the scheduler trace and the logs carry a request ID.
+// Returns the function which flushes the spans on exit.
+func setupTracing(endpoint string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(tracerName))),
+	}
+	if endpoint != "" {
+		exporter, err := otlptracegrpc.New(context.Background(),
+			otlptracegrpc.WithEndpoint(endpoint),
+			otlptracegrpc.WithInsecure(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Returns the context of the trace started by the scheduler, which annotates the pod with it
+func podTraceContext(annotations map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(annotations))
+}
+
+func podAttributes(name string, namespace string) trace.SpanStartOption {
+	return trace.WithAttributes(attribute.String("pod", namespace+"/"+name))
+}
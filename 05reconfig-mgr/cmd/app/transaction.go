@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// Outcomes of a reconfiguration, written to the reconfig_status annotation of the pod
+const (
+	outcomeSucceeded  string = "Succeeded"  // every device is attached to its target node
+	outcomeRolledBack string = "RolledBack" // a step failed and every device is back on its original host port
+	outcomeFailed     string = "Failed"     // a step failed and some devices could not be rolled back
+	outcomeAbandoned  string = "Abandoned"  // the request is dropped once the scheduler stopped waiting for it
+	outcomeDryRun     string = "DryRun"     // the plan is computed but nothing is moved
+)
+
+// Reasons of the events recorded on pods, "Reconfig" is the request from the scheduler and must not be used here
+const (
+	reasonReconfigApplied    string = "ReconfigApplied"
+	reasonReconfigRolledBack string = "ReconfigRolledBack"
+	reasonReconfigFailed     string = "ReconfigFailed"
+	reasonReconfigAbandoned  string = "ReconfigAbandoned"
+	reasonReconfigDryRun     string = "ReconfigDryRun"
+)
+
+type stepState int
+
+const (
+	stepPlanned    stepState = iota
+	stepDetached             // unassigned from its original host port
+	stepAttached             // assigned to the target host port
+	stepRolledBack           // back on its original host port
+	stepStranded             // could not be rolled back
+)
+
+// reconfigStep moves one device from its original host port to the port of a target node
+type reconfigStep struct {
+	devGID     string
+	fromPort   string // empty if the device is not attached to any host
+	toPort     string
+	targetNode string
+	state      stepState
+}
+
+// reconfigTxn is a plan of steps which is applied as a whole or not at all
+type reconfigTxn struct {
+	steps  []*reconfigStep
+	reason string // why the devices are moved, for the metrics
+}
+
+func newReconfigTxn(options []gpuOption, nodeNameToPort map[string]string, reason string) *reconfigTxn {
+	txn := &reconfigTxn{reason: reason}
+	for _, dev := range options {
+		txn.steps = append(txn.steps, &reconfigStep{
+			devGID:     dev.devGID,
+			fromPort:   dev.hostPort,
+			toPort:     nodeNameToPort[dev.targetNode],
+			targetNode: dev.targetNode,
+		})
+	}
+	return txn
+}
+
+// Applies the steps in order, the failed step and every step before it are rolled back on error
+func (d *ReconfigDaemon) applyTxn(ctx context.Context, txn *reconfigTxn) (outcome string, err error) {
+	logger := klog.FromContext(ctx)
+	start := time.Now()
+	defer func() {
+		reconfigDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}()
+
+	for i, step := range txn.steps {
+		if err := d.applyStep(ctx, step); err != nil {
+			logger.Error(err, "Step failed, rolling back", "step", i+1, "steps", len(txn.steps))
+			if !d.rollbackTxn(ctx, txn) {
+				return outcomeFailed, err
+			}
+			return outcomeRolledBack, err
+		}
+		devicesMoved.WithLabelValues(txn.reason).Inc()
+	}
+	return outcomeSucceeded, nil
+}
+
+func (d *ReconfigDaemon) applyStep(ctx context.Context, step *reconfigStep) error {
+	if step.fromPort != "" {
+		if err := d.unassign(ctx, step.devGID); err != nil {
+			return fmt.Errorf("unassign device %s from port %s: %v", step.devGID, step.fromPort, err)
+		}
+	}
+	step.state = stepDetached
+
+	if err := d.assign(ctx, step.toPort, step.devGID); err != nil {
+		return fmt.Errorf("assign device %s to port %s: %v", step.devGID, step.toPort, err)
+	}
+	step.state = stepAttached
+	return nil
+}
+
+// Moves the devices of the applied steps back to their original host ports in reverse order.
+// Returns false if any device could not be restored.
+func (d *ReconfigDaemon) rollbackTxn(ctx context.Context, txn *reconfigTxn) bool {
+	logger := klog.FromContext(ctx)
+	restored := true
+	for i := len(txn.steps) - 1; i >= 0; i-- {
+		step := txn.steps[i]
+		if step.state != stepAttached && step.state != stepDetached {
+			continue
+		}
+
+		if step.state == stepAttached {
+			if err := d.unassign(ctx, step.devGID); err != nil {
+				logger.Error(err, "Rollback failed, the device stays on the target port", "devid", step.devGID, "hostport", step.toPort)
+				step.state = stepStranded
+				restored = false
+				continue
+			}
+		}
+		if step.fromPort != "" {
+			if err := d.assign(ctx, step.fromPort, step.devGID); err != nil {
+				logger.Error(err, "Rollback failed, the device is detached from every host", "devid", step.devGID, "hostport", step.fromPort)
+				step.state = stepStranded
+				restored = false
+				continue
+			}
+		}
+		step.state = stepRolledBack
+		devicesMoved.WithLabelValues(moveForRollback).Inc()
+	}
+	return restored
+}
+
+// Returns the devices which could not be rolled back
+func (txn *reconfigTxn) stranded() []string {
+	var devs []string
+	for _, step := range txn.steps {
+		if step.state == stepStranded {
+			devs = append(devs, step.devGID)
+		}
+	}
+	return devs
+}
+
+// Writes the outcome to the reconfig_status annotation of the pod and records an event on it
+func (d *ReconfigDaemon) reportOutcome(name string, namespace string, outcome string, message string) {
+	reconfigOutcomes.WithLabelValues(outcome).Inc()
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				"reconfig_status": outcome,
+			},
+		},
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		klog.ErrorS(err, "Failed to marshal annotations")
+		return
+	}
+	po, err := d.clientset.CoreV1().Pods(namespace).Patch(context.TODO(), name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	if err != nil {
+		klog.ErrorS(err, "Failed to report the outcome", "pod", klog.KRef(namespace, name), "outcome", outcome)
+		return
+	}
+
+	switch outcome {
+	case outcomeSucceeded:
+		d.recorder.Event(po, v1.EventTypeNormal, reasonReconfigApplied, message)
+	case outcomeRolledBack:
+		d.recorder.Event(po, v1.EventTypeWarning, reasonReconfigRolledBack, message)
+	case outcomeFailed:
+		d.recorder.Event(po, v1.EventTypeWarning, reasonReconfigFailed, message)
+	case outcomeAbandoned:
+		d.recorder.Event(po, v1.EventTypeWarning, reasonReconfigAbandoned, message)
+	case outcomeDryRun:
+		d.recorder.Event(po, v1.EventTypeNormal, reasonReconfigDryRun, message)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"reconfig-daemon/pkg/inter"
+)
+
+// fakePool is a resource pool whose Nth Assign or Unassign call fails, counting from 1
+type fakePool struct {
+	alloc        map[string]string // DevID to HostPort, empty if unassigned
+	assigns      int
+	unassigns    int
+	failAssign   map[int]bool
+	failUnassign map[int]bool
+}
+
+var _ inter.DeviceInterface = &fakePool{}
+
+func (p *fakePool) GetAllResource() ([]inter.DevicePair, error) {
+	var devices []inter.DevicePair
+	for dev, port := range p.alloc {
+		devices = append(devices, inter.DevicePair{DevID: dev, HostPort: port})
+	}
+	return devices, nil
+}
+
+func (p *fakePool) Assign(ctx context.Context, hostPort string, devid string) (bool, error) {
+	p.assigns++
+	if p.failAssign[p.assigns] {
+		return false, fmt.Errorf("assign call %d failed", p.assigns)
+	}
+	if port := p.alloc[devid]; port != "" {
+		return false, fmt.Errorf("device %s is attached to port %s", devid, port)
+	}
+	p.alloc[devid] = hostPort
+	return true, nil
+}
+
+func (p *fakePool) Unassign(ctx context.Context, devid string) (bool, error) {
+	p.unassigns++
+	if p.failUnassign[p.unassigns] {
+		return false, fmt.Errorf("unassign call %d failed", p.unassigns)
+	}
+	if p.alloc[devid] == "" {
+		return false, fmt.Errorf("device %s is not attached", devid)
+	}
+	p.alloc[devid] = ""
+	return true, nil
+}
+
+func (p *fakePool) SetFencingToken(token int64) {}
+
+func (p *fakePool) SetEndpoints(getResourceEndpoint string, reconfigEndpoint string) {}
+
+func TestApplyTxn(t *testing.T) {
+	// d1 and d2 move from port A and d3 is pulled unattached, all to node n1 on port T
+	options := []gpuOption{
+		{devGID: "d1", hostPort: "A", targetNode: "n1"},
+		{devGID: "d2", hostPort: "A", targetNode: "n1"},
+		{devGID: "d3", hostPort: "", targetNode: "n1"},
+	}
+	original := map[string]string{"d1": "A", "d2": "A", "d3": ""}
+
+	tests := []struct {
+		name         string
+		failAssign   []int
+		failUnassign []int
+		wantOutcome  string
+		wantReason   string
+		wantAlloc    map[string]string
+		wantStranded []string
+	}{
+		{
+			name:        "every step succeeds",
+			wantOutcome: outcomeSucceeded,
+			wantReason:  reasonReconfigApplied,
+			wantAlloc:   map[string]string{"d1": "T", "d2": "T", "d3": "T"},
+		},
+		{
+			name:         "the first unassign fails before anything moves",
+			failUnassign: []int{1},
+			wantOutcome:  outcomeRolledBack,
+			wantReason:   reasonReconfigRolledBack,
+			wantAlloc:    original,
+		},
+		{
+			name:        "the second assign fails and the detached device is put back",
+			failAssign:  []int{2},
+			wantOutcome: outcomeRolledBack,
+			wantReason:  reasonReconfigRolledBack,
+			wantAlloc:   original,
+		},
+		{
+			name:        "assigning the unattached device fails and it stays unattached",
+			failAssign:  []int{3},
+			wantOutcome: outcomeRolledBack,
+			wantReason:  reasonReconfigRolledBack,
+			wantAlloc:   original,
+		},
+		{
+			name:         "unassigning an attached device fails during the rollback",
+			failAssign:   []int{3},
+			failUnassign: []int{3},
+			wantOutcome:  outcomeFailed,
+			wantReason:   reasonReconfigFailed,
+			wantAlloc:    map[string]string{"d1": "A", "d2": "T", "d3": ""},
+			wantStranded: []string{"d2"},
+		},
+		{
+			name:         "reassigning a detached device to its original port fails during the rollback",
+			failAssign:   []int{2, 3},
+			wantOutcome:  outcomeFailed,
+			wantReason:   reasonReconfigFailed,
+			wantAlloc:    map[string]string{"d1": "A", "d2": "", "d3": ""},
+			wantStranded: []string{"d2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &fakePool{
+				alloc:        map[string]string{},
+				failAssign:   map[int]bool{},
+				failUnassign: map[int]bool{},
+			}
+			for dev, port := range original {
+				pool.alloc[dev] = port
+			}
+			for _, n := range tt.failAssign {
+				pool.failAssign[n] = true
+			}
+			for _, n := range tt.failUnassign {
+				pool.failUnassign[n] = true
+			}
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default"}}
+			clientset := fake.NewSimpleClientset(pod)
+			recorder := record.NewFakeRecorder(10)
+			d := &ReconfigDaemon{
+				lastMoved: make(map[string]time.Time),
+				clientset: clientset,
+				devIF:     pool,
+				recorder:  recorder,
+			}
+
+			txn := newReconfigTxn(options, map[string]string{"n1": "T"}, moveForRequest)
+			outcome, err := d.applyTxn(context.Background(), txn)
+			if outcome != tt.wantOutcome {
+				t.Errorf("applyTxn() outcome = %s, want %s", outcome, tt.wantOutcome)
+			}
+			if (err != nil) != (tt.wantOutcome != outcomeSucceeded) {
+				t.Errorf("applyTxn() error = %v", err)
+			}
+			if !reflect.DeepEqual(pool.alloc, tt.wantAlloc) {
+				t.Errorf("devices are on ports %v, want %v", pool.alloc, tt.wantAlloc)
+			}
+			if stranded := txn.stranded(); !reflect.DeepEqual(stranded, tt.wantStranded) {
+				t.Errorf("stranded() = %v, want %v", stranded, tt.wantStranded)
+			}
+
+			d.reportOutcome(pod.Name, pod.Namespace, outcome, "message")
+			po, err := clientset.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status := po.Annotations["reconfig_status"]; status != tt.wantOutcome {
+				t.Errorf("reconfig_status = %q, want %q", status, tt.wantOutcome)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, " "+tt.wantReason+" ") {
+					t.Errorf("recorded event %q, want reason %s", event, tt.wantReason)
+				}
+			default:
+				t.Errorf("no event recorded, want reason %s", tt.wantReason)
+			}
+		})
+	}
+}
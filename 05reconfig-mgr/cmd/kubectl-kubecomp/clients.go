@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"kubecomp/topology"
+	"reconfig-daemon/pkg/apis/v1alpha1"
+	"reconfig-daemon/pkg/inter"
+)
+
+// device is a device of the pool, as served by GET /devices of reconfig-mgr
+type device struct {
+	DevID    string `json:"devid"`
+	HostPort string `json:"hostport"`
+	Node     string `json:"node,omitempty"`
+	Pod      string `json:"pod,omitempty"`
+	Reserved bool   `json:"reserved"`
+	Moving   bool   `json:"moving"`
+
+	ownersUnknown bool // read without reconfig-mgr, the pod, the reservation and the reconfiguration are unknown
+}
+
+// tokenHeader carries the bearer token of the user to reconfig-mgr through the service proxy
+const tokenHeader string = "X-Kubecomp-Authorization"
+
+// moveRequest is the body of POST /move of reconfig-mgr
+type moveRequest struct {
+	DevID    string `json:"devid"`
+	HostPort string `json:"hostport"`      // empty to detach the device
+	From     string `json:"from_hostport"` // the move is refused unless the device is still on this host port
+	Force    bool   `json:"force"`
+}
+
+// Returns the state of the device shown in the tables
+func (dev device) state() string {
+	switch {
+	case dev.Moving:
+		return "Moving"
+	case dev.Reserved:
+		return "Reserved"
+	case dev.Pod != "":
+		return "Owned"
+	case dev.HostPort == "":
+		return "Unassigned"
+	case dev.ownersUnknown:
+		return "Unknown"
+	}
+	return "Free"
+}
+
+// Returns the topology of the topology ConfigMap, or the default endpoints without it
+func (o *options) topology(ctx context.Context) (*topology.Topology, error) {
+	cm, err := o.clientset.CoreV1().ConfigMaps(o.kubecompNamespace).Get(ctx, o.topologyConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && cm.Data["topology.yaml"] == "") {
+		topo := &topology.Topology{}
+		topo.SetDefaults()
+		return topo, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return topology.Parse([]byte(cm.Data["topology.yaml"]))
+}
+
+// Returns the interface to the pool, through the service proxy of the API server unless --pool-url is given
+func (o *options) pool(ctx context.Context) (*inter.FalconInterface, error) {
+	if o.poolURL != "" {
+		base := strings.TrimSuffix(o.poolURL, "/")
+		return inter.NewDevInterfaceWithClient(base+"/resources", base+"/allocation", &http.Client{}), nil
+	}
+	topo, err := o.topology(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := o.proxyURL(topo.Endpoints.Resources)
+	if err != nil {
+		return nil, err
+	}
+	allocation, err := o.proxyURL(topo.Endpoints.Allocation)
+	if err != nil {
+		return nil, err
+	}
+	client, err := rest.HTTPClientFor(o.config)
+	if err != nil {
+		return nil, err
+	}
+	return inter.NewDevInterfaceWithClient(resources, allocation, client), nil
+}
+
+// Rewrites an in-cluster service URL, e.g. http://resource-pool-service.kubecomp.svc.cluster.local:8000/resources,
+// into its URL through the service proxy of the API server
+func (o *options) proxyURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	labels := strings.Split(u.Hostname(), ".")
+	service, namespace := labels[0], o.kubecompNamespace
+	if len(labels) > 1 {
+		namespace = labels[1]
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	return fmt.Sprintf("%s/api/v1/namespaces/%s/services/%s:%s:%s/proxy%s",
+		strings.TrimSuffix(o.config.Host, "/"), namespace, u.Scheme, service, port, u.Path), nil
+}
+
+// Gets the path from reconfig-mgr through the service proxy and decodes the JSON response into v
+func (o *options) getReconfigMgr(ctx context.Context, path string, v interface{}) error {
+	name, port, _ := strings.Cut(o.reconfigMgr, ":")
+	body, err := o.clientset.CoreV1().Services(o.kubecompNamespace).ProxyGet("http", name, port, path, nil).DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("reconfig-mgr %s: %v", path, err)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// Returns the bearer token of the kubeconfig or of --token. The service proxy strips the credentials of the user, so
+// they are sent again in a header of their own for reconfig-mgr to authenticate and authorize the user.
+func (o *options) bearerToken() (string, error) {
+	if o.config.BearerToken != "" {
+		return o.config.BearerToken, nil
+	}
+	if o.config.BearerTokenFile != "" {
+		token, err := os.ReadFile(o.config.BearerTokenFile)
+		return strings.TrimSpace(string(token)), err
+	}
+	return "", fmt.Errorf("reconfig-mgr authenticates the moves with a bearer token and the kubeconfig has none, pass one with --token")
+}
+
+// Posts the request to reconfig-mgr through the service proxy, authenticated with the bearer token
+func (o *options) postReconfigMgr(ctx context.Context, path string, token string, req interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	name, port, _ := strings.Cut(o.reconfigMgr, ":")
+	_, err = o.clientset.CoreV1().RESTClient().Post().Namespace(o.kubecompNamespace).Resource("services").
+		Name(fmt.Sprintf("http:%s:%s", name, port)).SubResource("proxy").Suffix(path).
+		SetHeader("Content-Type", "application/json").SetHeader(tokenHeader, "Bearer "+token).Body(body).DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("reconfig-mgr %s: %w", path, err)
+	}
+	return nil
+}
+
+// Returns whether reconfig-mgr could not be reached, rather than refused the request
+func reconfigMgrUnreachable(err error) bool {
+	var status apierrors.APIStatus
+	return !errors.As(err, &status) || apierrors.IsServiceUnavailable(err)
+}
+
+// Returns the devices of the pool with their owners from reconfig-mgr. Without reconfig-mgr, the devices are read
+// from the PoolDevice objects it mirrors, or from the pool if there are none, and their owners are unknown.
+func (o *options) devices(ctx context.Context) ([]device, error) {
+	var devices []device
+	err := o.getReconfigMgr(ctx, "/devices", &devices)
+	if err == nil {
+		return devices, nil
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v, the owners of the devices are unknown\n", err)
+
+	devices, err = o.mirroredDevices(ctx)
+	if err == nil && len(devices) > 0 {
+		return ownersUnknown(devices), nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to list the PoolDevice objects: %v, reading the pool\n", err)
+	}
+
+	pool, err := o.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := pool.GetAllResource()
+	if err != nil {
+		return nil, err
+	}
+	portNodes, err := o.portNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, dp := range pairs {
+		devices = append(devices, device{DevID: dp.DevID, HostPort: dp.HostPort, Node: portNodes[dp.HostPort]})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DevID < devices[j].DevID })
+	return ownersUnknown(devices), nil
+}
+
+// Marks the devices read without reconfig-mgr
+func ownersUnknown(devices []device) []device {
+	for i := range devices {
+		devices[i].ownersUnknown = true
+	}
+	return devices
+}
+
+// Returns the devices of the PoolDevice objects mirrored by reconfig-mgr, empty if the pool sync is disabled
+func (o *options) mirroredDevices(ctx context.Context) ([]device, error) {
+	client, err := dynamic.NewForConfig(o.config)
+	if err != nil {
+		return nil, err
+	}
+	list, err := client.Resource(v1alpha1.PoolDeviceResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]device, 0, len(list.Items))
+	for _, item := range list.Items {
+		var pd v1alpha1.PoolDevice
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &pd); err != nil {
+			return nil, fmt.Errorf("PoolDevice %s: %v", item.GetName(), err)
+		}
+		devices = append(devices, device{DevID: pd.Spec.DevID, HostPort: pd.Status.HostPort, Node: pd.Status.Node})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DevID < devices[j].DevID })
+	return devices, nil
+}
+
+// Returns the host port of each node, from the label set by the device plugin or the topology, like reconfig-mgr
+func (o *options) nodePorts(ctx context.Context, nodes []v1.Node) (map[string]string, error) {
+	topo, err := o.topology(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodePorts := topo.NodePorts()
+	for _, node := range nodes {
+		if port := node.Labels[topology.HostPortLabel]; port != "" {
+			nodePorts[node.Name] = port
+		}
+	}
+	return nodePorts, nil
+}
+
+// Returns the node cabled to each host port
+func (o *options) portNodes(ctx context.Context) (map[string]string, error) {
+	nodes, err := o.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodePorts, err := o.nodePorts(ctx, nodes.Items)
+	if err != nil {
+		return nil, err
+	}
+	portNodes := make(map[string]string, len(nodePorts))
+	for nodeName, port := range nodePorts {
+		portNodes[port] = nodeName
+	}
+	return portNodes, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+const (
+	unassignedConfigMap string = "pool-unassigned" // ConfigMap where reconfig-mgr publishes the unassigned GPUs for the scheduler
+	maxExplainEvents    int    = 10
+)
+
+// explanation tells why a pod waits, from its annotations, reconfig-mgr, its target nodes and its events
+type explanation struct {
+	Pod        string         `json:"pod"`
+	Phase      v1.PodPhase    `json:"phase"`
+	Node       string         `json:"node,omitempty"` // node the pod is bound to
+	GPUs       int64          `json:"gpus"`           // falcon.com/gpu requested by the pod
+	RequestID  string         `json:"requestID,omitempty"`
+	Plan       map[string]int `json:"plan,omitempty"` // GPUs missing on each target node, from the annotations of the scheduler
+	Queued     bool           `json:"queued"`         // the request waits in reconfig-mgr
+	Outcome    string         `json:"outcome,omitempty"`
+	Unassigned string         `json:"unassigned,omitempty"` // unassigned GPUs of the pool, as published by reconfig-mgr
+	Targets    []nodeGPUs     `json:"targets,omitempty"`
+	Events     []podEvent     `json:"events,omitempty"`
+	Reason     string         `json:"reason"`
+}
+
+type podEvent struct {
+	Type    string       `json:"type"`
+	Reason  string       `json:"reason"`
+	Message string       `json:"message"`
+	Time    *metav1.Time `json:"time"`
+}
+
+func newExplainCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explain",
+		Short: "Explain the state of KubeComp objects",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "pod NAME",
+		Short: "Explain why a pod is waiting in the Permit phase of the scheduler",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			e, err := o.explainPod(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			if o.output != outputTable {
+				return o.print(cmd.OutOrStdout(), e, nil, nil)
+			}
+			return e.describe(cmd.OutOrStdout())
+		},
+	})
+	return cmd
+}
+
+func (o *options) explainPod(ctx context.Context, name string) (*explanation, error) {
+	po, err := o.clientset.CoreV1().Pods(o.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	e := &explanation{
+		Pod:     po.Namespace + "/" + po.Name,
+		Phase:   po.Status.Phase,
+		Node:    po.Spec.NodeName,
+		GPUs:    podGPUs(po),
+		Outcome: po.Annotations["reconfig_status"],
+	}
+	// The traceparent is version-traceid-spanid-flags, the trace ID is the request ID of the logs
+	if parts := strings.Split(po.Annotations["traceparent"], "-"); len(parts) == 4 {
+		e.RequestID = parts[1]
+	}
+	if gangPlan := po.Annotations["gang_plan"]; gangPlan != "" {
+		if err := json.Unmarshal([]byte(gangPlan), &e.Plan); err != nil {
+			return nil, fmt.Errorf("invalid gang plan %q: %v", gangPlan, err)
+		}
+	} else if nodeName := po.Annotations["dst_node"]; nodeName != "" {
+		demand, _ := strconv.Atoi(po.Annotations["gpu_demand"])
+		e.Plan = map[string]int{nodeName: demand}
+	}
+
+	events, err := o.clientset.CoreV1().Events(po.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Pod,involvedObject.name=" + po.Name + ",involvedObject.uid=" + string(po.UID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range events.Items {
+		ev := &events.Items[i]
+		e.Events = append(e.Events, podEvent{Type: ev.Type, Reason: ev.Reason, Message: ev.Message, Time: eventTime(ev)})
+	}
+	sort.SliceStable(e.Events, func(i, j int) bool { return e.Events[i].Time.Before(e.Events[j].Time) })
+	if len(e.Events) > maxExplainEvents {
+		e.Events = e.Events[len(e.Events)-maxExplainEvents:]
+	}
+
+	if e.Node == "" && e.GPUs > 0 && e.Plan != nil {
+		var requests []struct {
+			Pod string `json:"pod"`
+		}
+		if err := o.getReconfigMgr(ctx, "/requests", &requests); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		for _, req := range requests {
+			e.Queued = e.Queued || req.Pod == e.Pod
+		}
+		cm, err := o.clientset.CoreV1().ConfigMaps(o.kubecompNamespace).Get(ctx, unassignedConfigMap, metav1.GetOptions{})
+		if err == nil {
+			e.Unassigned = cm.Data["unassigned"]
+		}
+		nodeNames := make([]string, 0, len(e.Plan))
+		for nodeName := range e.Plan {
+			nodeNames = append(nodeNames, nodeName)
+		}
+		sort.Strings(nodeNames)
+		for _, nodeName := range nodeNames {
+			gpus, err := o.nodeGPUs(ctx, nodeName)
+			if err != nil {
+				return nil, err
+			}
+			e.Targets = append(e.Targets, gpus...)
+		}
+	}
+	e.Reason = e.reason()
+	return e, nil
+}
+
+// Returns the falcon.com/gpu requested by the containers of the pod
+func podGPUs(po *v1.Pod) int64 {
+	var gpus int64
+	for _, c := range po.Spec.Containers {
+		if q, ok := c.Resources.Limits[gpuResource]; ok {
+			gpus += q.Value()
+		}
+	}
+	return gpus
+}
+
+// Returns the last event of the pod with one of the reasons
+func (e *explanation) lastEvent(reasons ...string) *podEvent {
+	for i := len(e.Events) - 1; i >= 0; i-- {
+		for _, reason := range reasons {
+			if e.Events[i].Reason == reason {
+				return &e.Events[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Returns why the pod waits, from the most to the least conclusive evidence
+func (e *explanation) reason() string {
+	if e.Node != "" {
+		return fmt.Sprintf("The pod is bound to node %s, it does not wait in Permit.", e.Node)
+	}
+	if e.GPUs == 0 {
+		return "The pod requests no falcon.com/gpu, the KubeComp scheduler does not hold it in Permit."
+	}
+	if e.Plan == nil {
+		msg := "The pod has not reached Permit: no node passed the filters of the scheduler."
+		if ev := e.lastEvent("InsufficientPoolGPU", "PodGroupIncomplete", "FailedScheduling"); ev != nil {
+			msg += " Last " + ev.Reason + ": " + ev.Message
+		}
+		return msg
+	}
+	// A request for a node in maintenance or fenced stays queued, the node is the cause
+	for _, t := range e.Targets {
+		if t.Status == "Maintenance" || t.Status == "Fenced" {
+			return fmt.Sprintf("The target node %s is %s, reconfig-mgr moves no GPU to it.", t.Node, strings.ToLower(t.Status))
+		}
+	}
+	if e.Queued {
+		return fmt.Sprintf("The reconfiguration request %s is queued in reconfig-mgr, which moves the missing GPUs to the target nodes in turn (%s unassigned GPUs in the pool).",
+			e.planString(), orUnknown(e.Unassigned))
+	}
+	if ev := e.lastEvent("ReconfigRolledBack", "ReconfigFailed", "ReconfigAbandoned", "ReconfigTimeout"); ev != nil {
+		if applied := e.lastEvent("ReconfigApplied"); applied == nil || applied.Time.Before(ev.Time) {
+			return fmt.Sprintf("The last reconfiguration did not complete (%s): %s", ev.Reason, ev.Message)
+		}
+	}
+	if e.Outcome == "DryRun" {
+		return "reconfig-mgr runs in dry-run mode, it computed the plan but moved no GPU."
+	}
+	return fmt.Sprintf("The GPUs of %s are attached, the pod waits for the device plugin to advertise them to kubelet as falcon.com/gpu.", e.planString())
+}
+
+func (e *explanation) planString() string {
+	buf, _ := json.Marshal(e.Plan)
+	return string(buf)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// Prints the explanation like kubectl describe
+func (e *explanation) describe(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Pod:\t%s\n", e.Pod)
+	fmt.Fprintf(w, "Phase:\t%s\n", e.Phase)
+	if e.Node != "" {
+		fmt.Fprintf(w, "Node:\t%s\n", e.Node)
+	}
+	fmt.Fprintf(w, "GPUs:\t%d\n", e.GPUs)
+	if e.RequestID != "" {
+		fmt.Fprintf(w, "Request ID:\t%s\n", e.RequestID)
+	}
+	if e.Plan != nil {
+		fmt.Fprintf(w, "Plan:\t%s\n", e.planString())
+		fmt.Fprintf(w, "Queued:\t%t\n", e.Queued)
+		fmt.Fprintf(w, "Unassigned GPUs:\t%s\n", orUnknown(e.Unassigned))
+	}
+	if e.Outcome != "" {
+		fmt.Fprintf(w, "Last Outcome:\t%s\n", e.Outcome)
+	}
+	if len(e.Targets) > 0 {
+		fmt.Fprintf(w, "Target Nodes:\n")
+		for _, t := range e.Targets {
+			fmt.Fprintf(w, "  %s\t%s, host port %s, %d attached, %d owned, %d free, %d allocatable\n",
+				t.Node, t.Status, orUnknown(t.HostPort), t.Attached, t.Owned, t.Free, t.Allocatable)
+		}
+	}
+	fmt.Fprintf(w, "Reason:\t%s\n", e.Reason)
+	if len(e.Events) > 0 {
+		fmt.Fprintf(w, "Events:\n  Type\tReason\tAge\tMessage\n")
+		for _, ev := range e.Events {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", ev.Type, ev.Reason, duration.HumanDuration(time.Since(ev.Time.Time)), ev.Message)
+		}
+	}
+	return w.Flush()
+}
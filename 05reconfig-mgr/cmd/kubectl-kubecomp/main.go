@@ -0,0 +1,89 @@
+// kubectl-kubecomp is the kubectl plugin for inspecting and operating the GPU pool, run as `kubectl kubecomp`.
+// It reads the pool through the same FalconInterface as reconfig-mgr, and reaches the pool and reconfig-mgr
+// through the service proxy of the API server, so only a kubeconfig is needed.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// options are the global flags and the clients built from them
+type options struct {
+	kubeconfig        string
+	context           string
+	token             string // bearer token of the user, reconfig-mgr authenticates the moves with it
+	namespace         string // namespace of the pods
+	allNamespaces     bool
+	kubecompNamespace string // namespace of reconfig-mgr and the ConfigMaps of the components
+	topologyConfigMap string
+	reconfigMgr       string // service name:port of reconfig-mgr
+	poolURL           string // base URL of the pool API, bypassing the service proxy
+	output            string
+
+	config    *rest.Config
+	clientset kubernetes.Interface
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	o := &options{}
+	cmd := &cobra.Command{
+		Use:          "kubectl-kubecomp",
+		Short:        "Inspect and operate the KubeComp GPU pool",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return o.complete()
+		},
+	}
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.kubeconfig, "kubeconfig", "", "path to the kubeconfig file")
+	flags.StringVar(&o.context, "context", "", "name of the kubeconfig context to use")
+	flags.StringVar(&o.token, "token", "", "bearer token to authenticate to the API server and reconfig-mgr, e.g. from kubectl create token")
+	flags.StringVarP(&o.namespace, "namespace", "n", "", "namespace of the pods, the namespace of the context by default")
+	flags.BoolVarP(&o.allNamespaces, "all-namespaces", "A", false, "list the reconfigurations of the pods in all namespaces")
+	flags.StringVar(&o.kubecompNamespace, "kubecomp-namespace", "kubecomp", "namespace of reconfig-mgr and the topology ConfigMap")
+	flags.StringVar(&o.topologyConfigMap, "topology-configmap", "falcon-topo", "ConfigMap holding topology.yaml, which gives the endpoints of the pool")
+	flags.StringVar(&o.reconfigMgr, "reconfig-mgr", "reconfig-mgr:8080", "service name:port of reconfig-mgr")
+	flags.StringVar(&o.poolURL, "pool-url", "", "base URL of the pool API, e.g. http://localhost:8000 after a port-forward, instead of the service proxy")
+	flags.StringVarP(&o.output, "output", "o", outputTable, "output format: table, json or yaml")
+
+	cmd.AddCommand(newPoolCommand(o), newReconfigCommand(o), newNodeCommand(o), newExplainCommand(o))
+	return cmd
+}
+
+// Builds the clients from the kubeconfig, like kubectl
+func (o *options) complete() error {
+	if o.output != outputTable && o.output != outputJSON && o.output != outputYAML {
+		return fmt.Errorf("invalid output format %q, expected %s, %s or %s", o.output, outputTable, outputJSON, outputYAML)
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: o.context,
+		AuthInfo:       clientcmdapi.AuthInfo{Token: o.token},
+	})
+	var err error
+	if o.config, err = clientConfig.ClientConfig(); err != nil {
+		return err
+	}
+	if o.namespace == "" {
+		if o.namespace, _, err = clientConfig.Namespace(); err != nil {
+			return err
+		}
+	}
+	o.clientset, err = kubernetes.NewForConfig(o.config)
+	return err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kubecomp/topology"
+)
+
+const (
+	gpuResource      v1.ResourceName = "falcon.com/gpu"
+	maintenanceTaint string          = "falcon.com/maintenance" // default maintenance taint of reconfig-mgr
+	fenceTaint       string          = "falcon.com/gpu-fenced"  // taint of the nodes fenced by reconfig-mgr
+)
+
+// nodeGPUs are the pool GPUs attached to a node
+type nodeGPUs struct {
+	Node        string `json:"node"`
+	Status      string `json:"status"` // Ready, NotReady, Maintenance or Fenced
+	HostPort    string `json:"hostport,omitempty"`
+	Pool        string `json:"pool,omitempty"`
+	Attached    int    `json:"attached"`
+	Owned       int    `json:"owned"` // attached GPUs used by pods
+	Free        int    `json:"free"`
+	Reserved    int    `json:"reserved"`    // attached GPUs reserved by the DRA driver
+	Allocatable int64  `json:"allocatable"` // falcon.com/gpu advertised by the device plugin
+}
+
+func newNodeCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "node",
+		Short: "Inspect the GPUs of the nodes",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "gpus [NODE]",
+		Short: "Show the pool GPUs attached to each node, or to the given node",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			nodeName := ""
+			if len(args) == 1 {
+				nodeName = args[0]
+			}
+			gpus, err := o.nodeGPUs(cmd.Context(), nodeName)
+			if err != nil {
+				return err
+			}
+			rows := make([][]string, 0, len(gpus))
+			for _, g := range gpus {
+				rows = append(rows, []string{g.Node, g.Status, g.HostPort, g.Pool, strconv.Itoa(g.Attached), strconv.Itoa(g.Owned),
+					strconv.Itoa(g.Free), strconv.Itoa(g.Reserved), strconv.FormatInt(g.Allocatable, 10)})
+			}
+			header := []string{"NODE", "STATUS", "HOSTPORT", "POOL", "ATTACHED", "OWNED", "FREE", "RESERVED", "ALLOCATABLE"}
+			return o.print(cmd.OutOrStdout(), gpus, header, rows)
+		},
+	})
+	return cmd
+}
+
+// Returns the GPUs of every node, or of the given node
+func (o *options) nodeGPUs(ctx context.Context, nodeName string) ([]nodeGPUs, error) {
+	var nodes []v1.Node
+	if nodeName != "" {
+		node, err := o.clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		nodes = []v1.Node{*node}
+	} else {
+		list, err := o.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		nodes = list.Items
+	}
+	topo, err := o.topology(ctx)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := o.devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	gpus := make([]nodeGPUs, 0, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		g := nodeGPUs{
+			Node:     node.Name,
+			Status:   nodeStatus(node),
+			HostPort: node.Labels[topology.HostPortLabel],
+			Pool:     node.Labels[topology.PoolLabel],
+		}
+		if hp, ok := topo.FindNode(node.Name, internalIP(node)); ok && g.HostPort == "" {
+			g.HostPort, g.Pool = hp.Port, hp.Pool
+		}
+		if q, ok := node.Status.Allocatable[gpuResource]; ok {
+			g.Allocatable = q.Value()
+		}
+		for _, dev := range devices {
+			if g.HostPort == "" || dev.HostPort != g.HostPort {
+				continue
+			}
+			g.Attached++
+			switch {
+			case dev.Reserved:
+				g.Reserved++
+			case dev.Pod != "":
+				g.Owned++
+			default:
+				g.Free++
+			}
+		}
+		gpus = append(gpus, g)
+	}
+	return gpus, nil
+}
+
+// Returns the status of the node, its fencing or maintenance taint before its readiness
+func nodeStatus(node *v1.Node) string {
+	status := "NotReady"
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady && cond.Status == v1.ConditionTrue {
+			status = "Ready"
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		switch taint.Key {
+		case fenceTaint:
+			return "Fenced"
+		case maintenanceTaint:
+			status = "Maintenance"
+		}
+	}
+	return status
+}
+
+func internalIP(node *v1.Node) string {
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == v1.NodeInternalIP {
+			return addr.Address
+		}
+	}
+	return ""
+}
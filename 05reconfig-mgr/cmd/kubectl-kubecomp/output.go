@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats of the -o flag
+const (
+	outputTable string = "table"
+	outputJSON  string = "json"
+	outputYAML  string = "yaml"
+)
+
+// Prints v as JSON or YAML, or the rows under the header as a table aligned like kubectl get
+func (o *options) print(out io.Writer, v interface{}, header []string, rows [][]string) error {
+	switch o.output {
+	case outputJSON:
+		buf, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(buf))
+		return err
+	case outputYAML:
+		buf, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(buf)
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		for i := range row {
+			if row[i] == "" {
+				row[i] = "<none>"
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPoolCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pool",
+		Short: "List and move the GPUs of the pool",
+	}
+	cmd.AddCommand(newPoolListCommand(o), newPoolAttachCommand(o), newPoolDetachCommand(o), newPoolMoveCommand(o))
+	return cmd
+}
+
+func newPoolListCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the GPUs of the pool with their host port, node and owning pod",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			devices, err := o.devices(cmd.Context())
+			if err != nil {
+				return err
+			}
+			rows := make([][]string, 0, len(devices))
+			for _, dev := range devices {
+				rows = append(rows, []string{dev.DevID, dev.HostPort, dev.Node, dev.Pod, dev.state()})
+			}
+			return o.print(cmd.OutOrStdout(), devices, []string{"DEVID", "HOSTPORT", "NODE", "POD", "STATE"}, rows)
+		},
+	}
+}
+
+// target is the host port given by --port, or the host port of the node given by --node
+type target struct {
+	node string
+	port string
+}
+
+func (t *target) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&t.node, "node", "", "node to attach the GPU to")
+	cmd.Flags().StringVar(&t.port, "port", "", "host port to attach the GPU to")
+	cmd.MarkFlagsMutuallyExclusive("node", "port")
+}
+
+// Returns the host port of the target
+func (o *options) resolve(ctx context.Context, t *target) (string, error) {
+	if t.port != "" {
+		return t.port, nil
+	}
+	if t.node == "" {
+		return "", fmt.Errorf("either --node or --port is required")
+	}
+	node, err := o.clientset.CoreV1().Nodes().Get(ctx, t.node, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	nodePorts, err := o.nodePorts(ctx, []v1.Node{*node})
+	if err != nil {
+		return "", err
+	}
+	if port := nodePorts[t.node]; port != "" {
+		return port, nil
+	}
+	return "", fmt.Errorf("node %s is cabled to no host port", t.node)
+}
+
+// Returns the device of the pool, and refuses to touch it while reconfig-mgr reconfigures it, and while a pod or a claim
+// holds it or its owners are unknown without reconfig-mgr, unless forced
+func (o *options) device(ctx context.Context, devID string, force bool) (device, error) {
+	devices, err := o.devices(ctx)
+	if err != nil {
+		return device{}, err
+	}
+	for _, dev := range devices {
+		if dev.DevID != devID {
+			continue
+		}
+		// reconfig-mgr never moves a device it is reconfiguring, even forced
+		if dev.Moving {
+			return dev, fmt.Errorf("device %s is being reconfigured by reconfig-mgr", devID)
+		}
+		if force {
+			return dev, nil
+		}
+		switch {
+		case dev.ownersUnknown:
+			return dev, fmt.Errorf("device %s may be used by a pod, a claim or reconfig-mgr, its owners are unknown without reconfig-mgr, use --force to override", devID)
+		case dev.Reserved:
+			return dev, fmt.Errorf("device %s is reserved by the DRA driver for a ResourceClaim, use --force to override", devID)
+		case dev.Pod != "":
+			return dev, fmt.Errorf("device %s is used by pod %s, use --force to override", devID, dev.Pod)
+		}
+		return dev, nil
+	}
+	return device{}, fmt.Errorf("device %s not found in the pool", devID)
+}
+
+func newPoolAttachCommand(o *options) *cobra.Command {
+	var force bool
+	t := &target{}
+	cmd := &cobra.Command{
+		Use:   "attach DEVID (--node NODE | --port HOSTPORT)",
+		Short: "Attach an unassigned GPU to a node",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			port, err := o.resolve(ctx, t)
+			if err != nil {
+				return err
+			}
+			dev, err := o.device(ctx, args[0], force)
+			if err != nil {
+				return err
+			}
+			if dev.HostPort != "" {
+				return fmt.Errorf("device %s is attached to host port %s, use pool move instead", dev.DevID, dev.HostPort)
+			}
+			if err := o.move(ctx, dev, port, force); err != nil {
+				return fmt.Errorf("failed to attach device %s to host port %s: %v", dev.DevID, port, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "device %s attached to host port %s\n", dev.DevID, port)
+			return nil
+		},
+	}
+	t.addFlags(cmd)
+	cmd.Flags().BoolVar(&force, "force", false, "attach the GPU even if a claim holds it or its owners are unknown")
+	return cmd
+}
+
+func newPoolDetachCommand(o *options) *cobra.Command {
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "detach DEVID",
+		Short: "Detach a GPU into the unassigned state",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			dev, err := o.device(ctx, args[0], force)
+			if err != nil {
+				return err
+			}
+			if dev.HostPort == "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "device %s is already unassigned\n", dev.DevID)
+				return nil
+			}
+			if err := o.move(ctx, dev, "", force); err != nil {
+				return fmt.Errorf("failed to detach device %s: %v", dev.DevID, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "device %s detached from host port %s\n", dev.DevID, dev.HostPort)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&force, "force", false, "detach the GPU even if a pod or a claim holds it, or its owners are unknown")
+	return cmd
+}
+
+func newPoolMoveCommand(o *options) *cobra.Command {
+	var force bool
+	t := &target{}
+	cmd := &cobra.Command{
+		Use:   "move DEVID (--node NODE | --port HOSTPORT)",
+		Short: "Move a GPU to another node, back to its host port if the attachment fails",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			port, err := o.resolve(ctx, t)
+			if err != nil {
+				return err
+			}
+			dev, err := o.device(ctx, args[0], force)
+			if err != nil {
+				return err
+			}
+			if dev.HostPort == port {
+				fmt.Fprintf(cmd.OutOrStdout(), "device %s is already attached to host port %s\n", dev.DevID, port)
+				return nil
+			}
+			if err := o.move(ctx, dev, port, force); err != nil {
+				return fmt.Errorf("failed to move device %s to host port %s: %v", dev.DevID, port, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "device %s moved from host port %s to %s\n", dev.DevID, dev.HostPort, port)
+			return nil
+		},
+	}
+	t.addFlags(cmd)
+	cmd.Flags().BoolVar(&force, "force", false, "move the GPU even if a pod or a claim holds it, or its owners are unknown")
+	return cmd
+}
+
+// Moves the device to the host port, or detaches it if port is empty, through reconfig-mgr, which holds the device
+// and its host ports like a reconfiguration and rolls a failed attachment back.
+// Only a forced move falls back to the pool API while reconfig-mgr is unreachable.
+func (o *options) move(ctx context.Context, dev device, port string, force bool) error {
+	token, err := o.bearerToken()
+	if err != nil {
+		return err
+	}
+	err = o.postReconfigMgr(ctx, "move", token, moveRequest{DevID: dev.DevID, HostPort: port, From: dev.HostPort, Force: force})
+	if err == nil || !force || !reconfigMgrUnreachable(err) {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v, moving the device through the pool API, without the fencing token of reconfig-mgr\n", err)
+
+	pool, err := o.pool(ctx)
+	if err != nil {
+		return err
+	}
+	if dev.HostPort != "" {
+		if _, err := pool.Unassign(ctx, dev.DevID); err != nil {
+			return fmt.Errorf("detach: %v", err)
+		}
+	}
+	if port == "" {
+		return nil
+	}
+	if _, err := pool.Assign(ctx, port, dev.DevID); err != nil {
+		err = fmt.Errorf("attach: %v", err)
+		if dev.HostPort == "" {
+			return err
+		}
+		if _, rollbackErr := pool.Assign(ctx, dev.HostPort, dev.DevID); rollbackErr != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to roll device %s back to host port %s, it is left unassigned: %v\n", dev.DevID, dev.HostPort, rollbackErr)
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "device %s rolled back to host port %s\n", dev.DevID, dev.HostPort)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// Reasons of the events recorded on pods by reconfig-mgr once a reconfiguration is over, and their outcome
+var outcomeReasons = map[string]string{
+	"ReconfigApplied":    "Succeeded",
+	"ReconfigRolledBack": "RolledBack",
+	"ReconfigFailed":     "Failed",
+	"ReconfigAbandoned":  "Abandoned",
+	"ReconfigDryRun":     "DryRun",
+}
+
+// reconfiguration is a reconfiguration request waiting in reconfig-mgr, or a completed one read from the pod events
+type reconfiguration struct {
+	Pod     string         `json:"pod"`
+	State   string         `json:"state"` // Pending, or the outcome of the reconfiguration
+	Plan    map[string]int `json:"plan,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Time    *metav1.Time   `json:"time,omitempty"`
+}
+
+func newReconfigCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reconfig",
+		Short: "Inspect the reconfigurations of reconfig-mgr",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the pending reconfigurations and the completed ones of the pods in the namespace",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reconfigs, err := o.reconfigurations(cmd.Context())
+			if err != nil {
+				return err
+			}
+			rows := make([][]string, 0, len(reconfigs))
+			for _, r := range reconfigs {
+				details, age := r.Message, ""
+				if r.Plan != nil {
+					buf, _ := json.Marshal(r.Plan)
+					details = string(buf)
+				}
+				if r.Time != nil {
+					age = duration.HumanDuration(time.Since(r.Time.Time))
+				}
+				rows = append(rows, []string{r.Pod, r.State, details, age})
+			}
+			return o.print(cmd.OutOrStdout(), reconfigs, []string{"POD", "STATE", "PLAN/MESSAGE", "AGE"}, rows)
+		},
+	})
+	return cmd
+}
+
+// Returns the pending reconfigurations first, then the completed ones from the newest
+func (o *options) reconfigurations(ctx context.Context) ([]reconfiguration, error) {
+	namespace := o.namespace
+	if o.allNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+
+	var requests []struct {
+		Pod  string         `json:"pod"`
+		Plan map[string]int `json:"plan"`
+	}
+	if err := o.getReconfigMgr(ctx, "/requests", &requests); err != nil {
+		return nil, err
+	}
+	var reconfigs []reconfiguration
+	for _, req := range requests {
+		if namespace == metav1.NamespaceAll || strings.HasPrefix(req.Pod, namespace+"/") {
+			reconfigs = append(reconfigs, reconfiguration{Pod: req.Pod, State: "Pending", Plan: req.Plan})
+		}
+	}
+
+	events, err := o.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: "involvedObject.kind=Pod"})
+	if err != nil {
+		return nil, err
+	}
+	var completed []reconfiguration
+	for i := range events.Items {
+		ev := &events.Items[i]
+		outcome, ok := outcomeReasons[ev.Reason]
+		if !ok {
+			continue
+		}
+		completed = append(completed, reconfiguration{
+			Pod:     ev.InvolvedObject.Namespace + "/" + ev.InvolvedObject.Name,
+			State:   outcome,
+			Message: ev.Message,
+			Time:    eventTime(ev),
+		})
+	}
+	sort.SliceStable(completed, func(i, j int) bool { return completed[j].Time.Before(completed[i].Time) })
+	return append(reconfigs, completed...), nil
+}
+
+// Returns the time the event last occurred
+func eventTime(ev *v1.Event) *metav1.Time {
+	switch {
+	case !ev.LastTimestamp.IsZero():
+		return &ev.LastTimestamp
+	case !ev.EventTime.IsZero():
+		return &metav1.Time{Time: ev.EventTime.Time}
+	}
+	return &ev.CreationTimestamp
+}
@@ -0,0 +1,118 @@
+// Package v1alpha1 holds the kubecomp.io/v1alpha1 resources, which mirror the composable infrastructure in the Kubernetes API.
+// They are cluster-scoped and written by the pool sync of reconfig-mgr, the kubectl plugin reads them without reconfig-mgr.
+// The objects are converted from and to unstructured objects of the dynamic client, so no generated code is needed.
+package v1alpha1
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const GroupName string = "kubecomp.io"
+
+var (
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+	ComposablePoolResource = SchemeGroupVersion.WithResource("composablepools")
+	PoolDeviceResource     = SchemeGroupVersion.WithResource("pooldevices")
+	HostPortResource       = SchemeGroupVersion.WithResource("hostports")
+)
+
+const (
+	// Labels of the PoolDevices and HostPorts, so that they can be listed by pool, host port or node
+	PoolLabel     string = "kubecomp.io/pool"
+	HostPortLabel string = "kubecomp.io/host-port"
+	NodeLabel     string = "kubecomp.io/node"
+)
+
+// ComposablePool is a pool of the topology and the resource pool API serving it
+type ComposablePool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ComposablePoolSpec   `json:"spec"`
+	Status ComposablePoolStatus `json:"status,omitempty"`
+}
+
+type ComposablePoolSpec struct {
+	ResourcesEndpoint  string `json:"resourcesEndpoint"`
+	AllocationEndpoint string `json:"allocationEndpoint"`
+}
+
+type ComposablePoolStatus struct {
+	HostPorts       int          `json:"hostPorts"`
+	AttachedDevices int          `json:"attachedDevices"`
+	LastSyncTime    *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+type ComposablePoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ComposablePool `json:"items"`
+}
+
+// PoolDevice is a device of the resource pool and the host port it is attached to
+type PoolDevice struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PoolDeviceSpec   `json:"spec"`
+	Status PoolDeviceStatus `json:"status,omitempty"`
+}
+
+type PoolDeviceSpec struct {
+	DevID string `json:"devID"`
+	UUID  string `json:"uuid,omitempty"`
+}
+
+// PoolDeviceStatus is the attachment of the device, all fields are empty while it is attached to no host port
+type PoolDeviceStatus struct {
+	HostPort string `json:"hostPort,omitempty"`
+	Pool     string `json:"pool,omitempty"`
+	Node     string `json:"node,omitempty"`
+}
+
+type PoolDeviceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []PoolDevice `json:"items"`
+}
+
+// HostPort is a port of a pool and the node cabled to it
+type HostPort struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HostPortSpec   `json:"spec"`
+	Status HostPortStatus `json:"status,omitempty"`
+}
+
+type HostPortSpec struct {
+	Port     string `json:"port"`
+	Pool     string `json:"pool,omitempty"`
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+type HostPortStatus struct {
+	AttachedDevices int `json:"attachedDevices"`
+}
+
+type HostPortList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []HostPort `json:"items"`
+}
+
+// Names of the objects, which must be valid DNS subdomains
+func PoolDeviceName(devID string) string {
+	return "dev-" + strings.ToLower(devID)
+}
+
+func HostPortName(port string) string {
+	return "port-" + strings.ToLower(port)
+}
@@ -1,32 +1,86 @@
 package inter
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// DeviceInterface reads and reconfigures the resource pool, it is implemented by FalconInterface
+type DeviceInterface interface {
+	GetAllResource() ([]DevicePair, error)
+	// The trace context of ctx is sent to the pool with the request
+	Assign(ctx context.Context, hostPort string, devid string) (bool, error)
+	Unassign(ctx context.Context, devid string) (bool, error)
+	// Sets the fencing token sent with every reconfiguration, the pool rejects tokens lower than the highest it has seen
+	SetFencingToken(token int64)
+	// Points the interface to other endpoints of the pool, used when the topology is reloaded
+	SetEndpoints(getResourceEndpoint string, reconfigEndpoint string)
+}
+
+var _ DeviceInterface = &FalconInterface{}
+
 type FalconInterface struct {
+	endpointLock        sync.RWMutex
 	getResourceEndpoint string
 	reconfigEndpoint    string
+	fencingToken        atomic.Int64 // negative if unset
+	client              *http.Client
 }
 
 type DevicePair struct {
 	DevID    string
+	UUID     string
 	HostPort string
 }
 
 func NewDevInterface(getResourceEndpoint string, reconfigEndpoint string) *FalconInterface {
-	return &FalconInterface{
+	return NewDevInterfaceWithClient(getResourceEndpoint, reconfigEndpoint, &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)})
+}
+
+// NewDevInterfaceWithClient sends the requests with the given client, e.g. through the service proxy of the API server
+func NewDevInterfaceWithClient(getResourceEndpoint string, reconfigEndpoint string, client *http.Client) *FalconInterface {
+	fi := &FalconInterface{
 		getResourceEndpoint: getResourceEndpoint,
 		reconfigEndpoint:    reconfigEndpoint,
+		client:              client,
 	}
+	fi.fencingToken.Store(-1)
+	return fi
+}
+
+func (fi *FalconInterface) SetFencingToken(token int64) {
+	fi.fencingToken.Store(token)
+}
+
+func (fi *FalconInterface) SetEndpoints(getResourceEndpoint string, reconfigEndpoint string) {
+	fi.endpointLock.Lock()
+	defer fi.endpointLock.Unlock()
+	fi.getResourceEndpoint = getResourceEndpoint
+	fi.reconfigEndpoint = reconfigEndpoint
+}
+
+// Returns the current endpoints to get the resources and to reconfigure them
+func (fi *FalconInterface) endpoints() (string, string) {
+	fi.endpointLock.RLock()
+	defer fi.endpointLock.RUnlock()
+	return fi.getResourceEndpoint, fi.reconfigEndpoint
 }
 
 func (fi *FalconInterface) GetAllResource() ([]DevicePair, error) {
-	body, err := fi.sendRequest(http.MethodGet, fi.getResourceEndpoint, nil)
+	getResourceEndpoint, _ := fi.endpoints()
+	body, err := fi.sendRequest(context.Background(), http.MethodGet, getResourceEndpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -42,41 +96,55 @@ func (fi *FalconInterface) GetAllResource() ([]DevicePair, error) {
 	for _, res := range result {
 		devices = append(devices, DevicePair{
 			DevID:    res["devid"],
+			UUID:     res["uuid"],
 			HostPort: res["hostport"],
 		})
 	}
 	return devices, nil
 }
 
-func (fi *FalconInterface) Assign(hostPort string, devid string) (bool, error) {
+func (fi *FalconInterface) Assign(ctx context.Context, hostPort string, devid string) (bool, error) {
+	ctx, span := otel.Tracer("reconfig-mgr").Start(ctx, "FalconInterface.Assign")
+	defer span.End()
+	span.SetAttributes(attribute.String("devid", devid), attribute.String("hostport", hostPort))
+
 	param := fmt.Sprintf(`{"hostport" : "%s", "devid" : "%s"}`, hostPort, devid)
-	_, err := fi.sendRequest(http.MethodPost, fi.reconfigEndpoint, strings.NewReader(param))
+	_, reconfigEndpoint := fi.endpoints()
+	_, err := fi.sendRequest(ctx, http.MethodPost, reconfigEndpoint, strings.NewReader(param))
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return false, err
 	}
 	return true, nil
 }
 
-func (fi *FalconInterface) Unassign(devid string) (bool, error) {
+func (fi *FalconInterface) Unassign(ctx context.Context, devid string) (bool, error) {
+	ctx, span := otel.Tracer("reconfig-mgr").Start(ctx, "FalconInterface.Unassign")
+	defer span.End()
+	span.SetAttributes(attribute.String("devid", devid))
+
 	param := fmt.Sprintf(`{"devid" : "%s"}`, devid)
-	_, err := fi.sendRequest(http.MethodDelete, fi.reconfigEndpoint, strings.NewReader(param))
+	_, reconfigEndpoint := fi.endpoints()
+	_, err := fi.sendRequest(ctx, http.MethodDelete, reconfigEndpoint, strings.NewReader(param))
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return false, err
 	}
 	return true, nil
 }
 
-func (fi *FalconInterface) sendRequest(method, url string, payload io.Reader) ([]byte, error) {
-	client := &http.Client{}
-
-	req, err := http.NewRequest(method, url, payload)
+func (fi *FalconInterface) sendRequest(ctx context.Context, method, url string, payload io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if token := fi.fencingToken.Load(); token >= 0 && method != http.MethodGet {
+		req.Header.Set("X-Fencing-Token", strconv.FormatInt(token, 10))
+	}
 
 	// Performs the request
-	res, err := client.Do(req)
+	res, err := fi.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %v", err)
 	}
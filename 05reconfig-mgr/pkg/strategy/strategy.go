@@ -0,0 +1,199 @@
+package strategy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	DrainSmallestDonor string = "drain-smallest-donor" // takes GPUs from the host port with the fewest free GPUs first
+	BalanceRemaining   string = "balance-remaining"    // takes GPUs from the host port with the most free GPUs first
+	PreferSameSwitch   string = "prefer-same-switch"   // takes GPUs behind the same switch as the target first
+	LeastRecentlyMoved string = "least-recently-moved" // takes the GPUs which have not been moved for the longest time first
+	MinDisruption      string = "min-disruption"       // takes unattached GPUs, then GPUs of hosts running the fewest GPU pods first
+)
+
+// Device is a pool device which may be moved to the target host port
+type Device struct {
+	DevID     string
+	HostPort  string // empty if the device is not attached to any host
+	LastMoved time.Time
+}
+
+// Request describes the devices a strategy selects from
+type Request struct {
+	TargetPort string
+	Demand     int
+	Candidates []Device          // free devices which are not attached to the target
+	Switches   map[string]string // HostPort to the switch it is behind
+	PodsOnPort map[string]int    // HostPort to the number of GPU pods running on its node
+}
+
+// SelectionStrategy chooses the devices to move to the target host port
+type SelectionStrategy interface {
+	Name() string
+	// Returns at most Demand devices from the candidates, in the order they should be moved
+	Select(req Request) []Device
+}
+
+// Returns the built-in strategy with the given name, the current behavior is used if name is empty
+func New(name string) (SelectionStrategy, error) {
+	switch name {
+	case "", DrainSmallestDonor:
+		return &drainSmallestDonor{}, nil
+	case BalanceRemaining:
+		return &balanceRemaining{}, nil
+	case PreferSameSwitch:
+		return &preferSameSwitch{}, nil
+	case LeastRecentlyMoved:
+		return &leastRecentlyMoved{}, nil
+	case MinDisruption:
+		return &minDisruption{}, nil
+	}
+	return nil, fmt.Errorf("unknown selection strategy %q", name)
+}
+
+// Returns the strategy names which can be given to New
+func Names() []string {
+	return []string{DrainSmallestDonor, BalanceRemaining, PreferSameSwitch, LeastRecentlyMoved, MinDisruption}
+}
+
+// Returns the number of candidates attached to each host port
+func countByPort(candidates []Device) map[string]int {
+	counts := make(map[string]int)
+	for _, dev := range candidates {
+		counts[dev.HostPort]++
+	}
+	return counts
+}
+
+// Sorts a copy of the candidates with less and returns the first demand devices
+func sortAndTake(candidates []Device, demand int, less func(a, b Device) bool) []Device {
+	sorted := make([]Device, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if less(sorted[i], sorted[j]) {
+			return true
+		}
+		if less(sorted[j], sorted[i]) {
+			return false
+		}
+		return sorted[i].DevID < sorted[j].DevID
+	})
+	if demand < 0 {
+		demand = 0
+	}
+	if len(sorted) > demand {
+		sorted = sorted[:demand]
+	}
+	return sorted
+}
+
+type drainSmallestDonor struct{}
+
+func (s *drainSmallestDonor) Name() string {
+	return DrainSmallestDonor
+}
+
+func (s *drainSmallestDonor) Select(req Request) []Device {
+	counts := countByPort(req.Candidates)
+	return sortAndTake(req.Candidates, req.Demand, func(a, b Device) bool {
+		if counts[a.HostPort] != counts[b.HostPort] {
+			return counts[a.HostPort] < counts[b.HostPort]
+		}
+		return a.HostPort < b.HostPort
+	})
+}
+
+type balanceRemaining struct{}
+
+func (s *balanceRemaining) Name() string {
+	return BalanceRemaining
+}
+
+// Takes one GPU at a time from the host port with the most GPUs left, so the donors end up even
+func (s *balanceRemaining) Select(req Request) []Device {
+	// Orders the devices of each host port by DevID
+	byPort := make(map[string][]Device)
+	for _, dev := range sortAndTake(req.Candidates, len(req.Candidates), func(a, b Device) bool { return false }) {
+		byPort[dev.HostPort] = append(byPort[dev.HostPort], dev)
+	}
+
+	var selected []Device
+	for len(selected) < req.Demand {
+		best := ""
+		for port, devs := range byPort {
+			if len(devs) == 0 {
+				continue
+			}
+			if best == "" || len(devs) > len(byPort[best]) || (len(devs) == len(byPort[best]) && port < best) {
+				best = port
+			}
+		}
+		if best == "" {
+			break
+		}
+		selected = append(selected, byPort[best][0])
+		byPort[best] = byPort[best][1:]
+	}
+	return selected
+}
+
+type preferSameSwitch struct{}
+
+func (s *preferSameSwitch) Name() string {
+	return PreferSameSwitch
+}
+
+func (s *preferSameSwitch) Select(req Request) []Device {
+	targetSwitch, known := req.Switches[req.TargetPort]
+	counts := countByPort(req.Candidates)
+	return sortAndTake(req.Candidates, req.Demand, func(a, b Device) bool {
+		if known {
+			aSame, bSame := req.Switches[a.HostPort] == targetSwitch, req.Switches[b.HostPort] == targetSwitch
+			if aSame != bSame {
+				return aSame
+			}
+		}
+		if counts[a.HostPort] != counts[b.HostPort] {
+			return counts[a.HostPort] < counts[b.HostPort]
+		}
+		return a.HostPort < b.HostPort
+	})
+}
+
+type leastRecentlyMoved struct{}
+
+func (s *leastRecentlyMoved) Name() string {
+	return LeastRecentlyMoved
+}
+
+func (s *leastRecentlyMoved) Select(req Request) []Device {
+	return sortAndTake(req.Candidates, req.Demand, func(a, b Device) bool {
+		return a.LastMoved.Before(b.LastMoved)
+	})
+}
+
+type minDisruption struct{}
+
+func (s *minDisruption) Name() string {
+	return MinDisruption
+}
+
+func (s *minDisruption) Select(req Request) []Device {
+	counts := countByPort(req.Candidates)
+	return sortAndTake(req.Candidates, req.Demand, func(a, b Device) bool {
+		aFree, bFree := a.HostPort == "", b.HostPort == ""
+		if aFree != bFree {
+			return aFree
+		}
+		if req.PodsOnPort[a.HostPort] != req.PodsOnPort[b.HostPort] {
+			return req.PodsOnPort[a.HostPort] < req.PodsOnPort[b.HostPort]
+		}
+		if counts[a.HostPort] != counts[b.HostPort] {
+			return counts[a.HostPort] < counts[b.HostPort]
+		}
+		return a.HostPort < b.HostPort
+	})
+}
@@ -0,0 +1,165 @@
+package strategy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Candidates attached to three host ports holding one, two and three free GPUs
+func candidates() []Device {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []Device{
+		{DevID: "c3", HostPort: "C", LastMoved: base.Add(6 * time.Minute)},
+		{DevID: "a1", HostPort: "A", LastMoved: base.Add(5 * time.Minute)},
+		{DevID: "b2", HostPort: "B", LastMoved: base.Add(4 * time.Minute)},
+		{DevID: "c1", HostPort: "C", LastMoved: base.Add(3 * time.Minute)},
+		{DevID: "b1", HostPort: "B", LastMoved: base.Add(1 * time.Minute)},
+		{DevID: "c2", HostPort: "C", LastMoved: base.Add(2 * time.Minute)},
+	}
+}
+
+func devIDs(devs []Device) []string {
+	ids := []string{}
+	for _, dev := range devs {
+		ids = append(ids, dev.DevID)
+	}
+	return ids
+}
+
+func TestSelect(t *testing.T) {
+	switches := map[string]string{"T": "s2", "A": "s1", "B": "s2", "C": "s2"}
+	unattached := append(candidates(), Device{DevID: "u1"})
+
+	tests := []struct {
+		name     string
+		strategy string
+		req      Request
+		want     []string
+	}{
+		{
+			name:     "drain smallest donor takes the port with the fewest GPUs first",
+			strategy: DrainSmallestDonor,
+			req:      Request{TargetPort: "T", Demand: 3, Candidates: candidates()},
+			want:     []string{"a1", "b1", "b2"},
+		},
+		{
+			name:     "drain smallest donor returns every candidate when the demand is larger",
+			strategy: DrainSmallestDonor,
+			req:      Request{TargetPort: "T", Demand: 10, Candidates: candidates()},
+			want:     []string{"a1", "b1", "b2", "c1", "c2", "c3"},
+		},
+		{
+			name:     "drain smallest donor selects nothing without demand",
+			strategy: DrainSmallestDonor,
+			req:      Request{TargetPort: "T", Demand: 0, Candidates: candidates()},
+			want:     []string{},
+		},
+		{
+			name:     "balance remaining takes from the port with the most GPUs left",
+			strategy: BalanceRemaining,
+			req:      Request{TargetPort: "T", Demand: 3, Candidates: candidates()},
+			want:     []string{"c1", "b1", "c2"},
+		},
+		{
+			name:     "balance remaining returns every candidate when the demand is larger",
+			strategy: BalanceRemaining,
+			req:      Request{TargetPort: "T", Demand: 10, Candidates: candidates()},
+			want:     []string{"c1", "b1", "c2", "a1", "b2", "c3"},
+		},
+		{
+			name:     "prefer same switch takes the ports behind the switch of the target first",
+			strategy: PreferSameSwitch,
+			req:      Request{TargetPort: "T", Demand: 3, Candidates: candidates(), Switches: switches},
+			want:     []string{"b1", "b2", "c1"},
+		},
+		{
+			name:     "prefer same switch falls back to the other switches when the demand is larger",
+			strategy: PreferSameSwitch,
+			req:      Request{TargetPort: "T", Demand: 10, Candidates: candidates(), Switches: switches},
+			want:     []string{"b1", "b2", "c1", "c2", "c3", "a1"},
+		},
+		{
+			name:     "prefer same switch drains the smallest donor when the switch of the target is unknown",
+			strategy: PreferSameSwitch,
+			req:      Request{TargetPort: "U", Demand: 3, Candidates: candidates(), Switches: switches},
+			want:     []string{"a1", "b1", "b2"},
+		},
+		{
+			name:     "prefer same switch drains the smallest donor without switches",
+			strategy: PreferSameSwitch,
+			req:      Request{TargetPort: "T", Demand: 3, Candidates: candidates()},
+			want:     []string{"a1", "b1", "b2"},
+		},
+		{
+			name:     "least recently moved takes the oldest moves first",
+			strategy: LeastRecentlyMoved,
+			req:      Request{TargetPort: "T", Demand: 3, Candidates: candidates()},
+			want:     []string{"b1", "c2", "c1"},
+		},
+		{
+			name:     "least recently moved takes the never moved GPUs first",
+			strategy: LeastRecentlyMoved,
+			req:      Request{TargetPort: "T", Demand: 2, Candidates: unattached},
+			want:     []string{"u1", "b1"},
+		},
+		{
+			name:     "least recently moved returns every candidate when the demand is larger",
+			strategy: LeastRecentlyMoved,
+			req:      Request{TargetPort: "T", Demand: 10, Candidates: candidates()},
+			want:     []string{"b1", "c2", "c1", "b2", "a1", "c3"},
+		},
+		{
+			name:     "min disruption takes the unattached GPUs, then the ports with the fewest pods",
+			strategy: MinDisruption,
+			req:      Request{TargetPort: "T", Demand: 3, Candidates: unattached, PodsOnPort: map[string]int{"B": 2, "C": 1}},
+			want:     []string{"u1", "a1", "c1"},
+		},
+		{
+			name:     "min disruption drains the smallest donor among ports with as many pods",
+			strategy: MinDisruption,
+			req:      Request{TargetPort: "T", Demand: 3, Candidates: candidates()},
+			want:     []string{"a1", "b1", "b2"},
+		},
+		{
+			name:     "min disruption returns every candidate when the demand is larger",
+			strategy: MinDisruption,
+			req:      Request{TargetPort: "T", Demand: 10, Candidates: unattached, PodsOnPort: map[string]int{"B": 2, "C": 1}},
+			want:     []string{"u1", "a1", "c1", "c2", "c3", "b1", "b2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := devIDs(tt.req.Candidates)
+			got := devIDs(s.Select(tt.req))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select() = %v, want %v", got, tt.want)
+			}
+			if after := devIDs(tt.req.Candidates); !reflect.DeepEqual(before, after) {
+				t.Errorf("Select() reordered the candidates to %v", after)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range Names() {
+		s, err := New(name)
+		if err != nil {
+			t.Fatalf("New(%q) failed: %v", name, err)
+		}
+		if s.Name() != name {
+			t.Errorf("New(%q).Name() = %q", name, s.Name())
+		}
+	}
+	if s, err := New(""); err != nil || s.Name() != DrainSmallestDonor {
+		t.Errorf("New(\"\") = %v, %v, want %s", s, err, DrainSmallestDonor)
+	}
+	if _, err := New("random"); err == nil {
+		t.Error("New(\"random\") succeeded, want an error")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/dynamic-resource-allocation/controller"
+	"k8s.io/klog/v2"
+
+	"dra-driver/pkg/driver"
+	"dra-driver/pkg/inter"
+	"kubecomp/topology"
+)
+
+func main() {
+	config, err := driver.LoadConfig()
+	driver.SetupLogging(config)
+	defer klog.Flush()
+	if err != nil {
+		fatal(err, "Invalid config", "path", driver.ConfigPath)
+	}
+	topo := driver.LoadTopology()
+
+	// Invalid optional values fall back to their defaults
+	workers, err := strconv.Atoi(config["workers"])
+	if err != nil || workers < 1 {
+		workers = 4
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = "kubecomp"
+	}
+	reservedConfigMap := config["reserved_configmap"]
+	if reservedConfigMap == "" {
+		reservedConfigMap = "pool-dra-reserved"
+	}
+	moveEndpoint := config["reconfig_mgr_endpoint"]
+	if moveEndpoint == "" {
+		moveEndpoint = inter.DefaultMoveEndpoint
+	}
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		fatal(err, "Failed to get in-cluster config")
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		fatal(err, "Failed to create clientset")
+	}
+
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	d := driver.New(clientset, factory.Core().V1().Nodes().Lister(), topo, moveEndpoint, restConfig.BearerTokenFile, namespace, reservedConfigMap)
+	err = topology.Watch(topology.DefaultPath, nil, d.SetTopology, func(err error) {
+		klog.ErrorS(err, "Ignoring the topology change")
+	})
+	if err != nil {
+		klog.ErrorS(err, "Failed to watch the topology, changes require a restart", "path", topology.DefaultPath)
+	}
+
+	ctx := klog.NewContext(context.Background(), klog.Background())
+	ctrl := controller.New(ctx, driver.DriverName, d, clientset, factory)
+	factory.Start(ctx.Done())
+	klog.InfoS("DRA controller starts", "driver", driver.DriverName, "workers", workers, "moveEndpoint", moveEndpoint, "reservedConfigMap", klog.KRef(namespace, reservedConfigMap))
+	ctrl.Run(workers)
+}
+
+// Logs the error and exits
+func fatal(err error, msg string, keysAndValues ...interface{}) {
+	klog.ErrorS(err, msg, keysAndValues...)
+	klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/klog/v2"
+
+	"dra-driver/pkg/driver"
+	"dra-driver/pkg/inter"
+	"dra-driver/pkg/plugin"
+	"kubecomp/topology"
+)
+
+const (
+	pluginRegistrationPath string = "/var/lib/kubelet/plugins_registry"
+	pluginPath             string = "/var/lib/kubelet/plugins"
+)
+
+func main() {
+	config, err := driver.LoadConfig()
+	driver.SetupLogging(config)
+	defer klog.Flush()
+	if err != nil {
+		fatal(err, "Invalid config", "path", driver.ConfigPath)
+	}
+	topo := driver.LoadTopology()
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		fatal(nil, "NODE_NAME is not set")
+	}
+	cdiRoot := config["cdi_root"]
+	if cdiRoot == "" {
+		cdiRoot = plugin.DefaultCDIRoot
+	}
+	if err := os.MkdirAll(cdiRoot, 0755); err != nil {
+		fatal(err, "Failed to create the CDI directory", "path", cdiRoot)
+	}
+
+	// The kubelet plugin only reads the devices, it never moves them
+	pool := inter.NewDevInterface(topo.Endpoints.Resources, "")
+	err = topology.Watch(topology.DefaultPath, nil, func(topo *topology.Topology) {
+		pool.SetResourceEndpoint(topo.Endpoints.Resources)
+	}, func(err error) {
+		klog.ErrorS(err, "Ignoring the topology change")
+	})
+	if err != nil {
+		klog.ErrorS(err, "Failed to watch the topology, changes require a restart", "path", topology.DefaultPath)
+	}
+
+	// Kubelet finds the plugin through its registration socket, and calls it on its plugin socket
+	pluginSocket := filepath.Join(pluginPath, driver.DriverName, "plugin.sock")
+	if err := os.MkdirAll(filepath.Dir(pluginSocket), 0750); err != nil {
+		fatal(err, "Failed to create the plugin directory", "path", filepath.Dir(pluginSocket))
+	}
+	draPlugin, err := kubeletplugin.Start(plugin.NewNodeServer(nodeName, cdiRoot, pool),
+		kubeletplugin.DriverName(driver.DriverName),
+		kubeletplugin.RegistrarSocketPath(filepath.Join(pluginRegistrationPath, driver.DriverName+".sock")),
+		kubeletplugin.PluginSocketPath(pluginSocket),
+		kubeletplugin.KubeletPluginSocketPath(pluginSocket))
+	if err != nil {
+		fatal(err, "Failed to start the kubelet plugin")
+	}
+	defer draPlugin.Stop()
+	klog.InfoS("DRA kubelet plugin starts", "driver", driver.DriverName, "node", nodeName, "cdiRoot", cdiRoot)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+}
+
+// Logs the error and exits
+func fatal(err error, msg string, keysAndValues ...interface{}) {
+	klog.ErrorS(err, msg, keysAndValues...)
+	klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+}
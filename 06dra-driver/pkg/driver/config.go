@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+
+	"kubecomp/topology"
+)
+
+const ConfigPath string = "/etc/kubernetes/dra-driver-config.yaml"
+
+// LoadConfig reads the flat key-value config file shared by the controller and the kubelet plugin, a missing file is empty
+func LoadConfig() (map[string]string, error) {
+	config := map[string]string{}
+	buf, err := os.ReadFile(ConfigPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(buf, &config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SetupLogging registers the klog flags and sets the verbosity from the log_level key, an invalid level is logged
+func SetupLogging(config map[string]string) {
+	klog.InitFlags(nil)
+	if config["log_level"] == "" {
+		return
+	}
+	if err := flag.Set("v", config["log_level"]); err != nil {
+		klog.ErrorS(err, "Invalid log level", "level", config["log_level"])
+	}
+}
+
+// LoadTopology reads the topology shared with the device plugin and reconfig-mgr. Without a valid file,
+// the default endpoints of the resource pool are used and the host ports come from the node labels only.
+func LoadTopology() *topology.Topology {
+	topo, err := topology.Load(topology.DefaultPath)
+	if errors.Is(err, os.ErrNotExist) {
+		klog.InfoS("Topology file not found, using the default endpoints and the host port labels", "path", topology.DefaultPath)
+		return topology.Default()
+	}
+	if err != nil {
+		klog.ErrorS(err, "Invalid topology, using the default endpoints and the host port labels until it is fixed")
+		return topology.Default()
+	}
+	return topo
+}
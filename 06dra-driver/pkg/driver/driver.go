@@ -0,0 +1,353 @@
+// Package driver allocates the ResourceClaims of the falcon GPU class against the devices of the resource pool.
+// A claim is allocated once the scheduler has selected the node of its pod: unassigned devices are reserved,
+// attached to the host port of the node, and the allocation is restricted to that node.
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	resourcev1alpha2 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/dynamic-resource-allocation/controller"
+	"k8s.io/klog/v2"
+
+	"dra-driver/pkg/inter"
+	"kubecomp/topology"
+)
+
+// ClaimParameters are read from the ConfigMap referenced by the parametersRef of a claim, a claim without one asks for a GPU
+type ClaimParameters struct {
+	Count int
+}
+
+// Driver implements the controller side of the DRA driver
+type Driver struct {
+	// Serializes the allocations and deallocations, so that two claims never reserve the same device
+	lock sync.Mutex
+
+	clientset  kubernetes.Interface
+	nodeLister corelisters.NodeLister
+	pool       *inter.FalconInterface
+	reserved   *reservations
+
+	topoLock sync.RWMutex
+	topo     *topology.Topology // reloaded when its ConfigMap changes
+}
+
+var _ controller.Driver = &Driver{}
+
+// New returns the driver, which publishes its reserved devices in the ConfigMap namespace/name
+// and attaches them through the moveEndpoint of reconfig-mgr, authenticated with the token of tokenFile
+func New(clientset kubernetes.Interface, nodeLister corelisters.NodeLister, topo *topology.Topology, moveEndpoint string, tokenFile string, namespace string, name string) *Driver {
+	d := &Driver{
+		clientset:  clientset,
+		nodeLister: nodeLister,
+		pool:       inter.NewDevInterface(topo.Endpoints.Resources, moveEndpoint),
+		reserved:   &reservations{client: clientset, namespace: namespace, name: name},
+	}
+	d.pool.SetTokenFile(tokenFile)
+	d.SetTopology(topo)
+	return d
+}
+
+// SetTopology applies a topology loaded at startup or reloaded
+func (d *Driver) SetTopology(topo *topology.Topology) {
+	d.topoLock.Lock()
+	d.topo = topo
+	d.topoLock.Unlock()
+	d.pool.SetResourceEndpoint(topo.Endpoints.Resources)
+}
+
+// Returns the host port of the node with its pool. The host port is labeled by the device plugin or found in the
+// topology by name, then by IP. The pool is labeled on the node, else it is the pool of the host port in the topology.
+func (d *Driver) hostPort(nodeName string) (topology.HostPort, error) {
+	node, err := d.nodeLister.Get(nodeName)
+	if err != nil {
+		return topology.HostPort{}, err
+	}
+	return d.nodeHostPort(node)
+}
+
+func (d *Driver) nodeHostPort(node *v1.Node) (topology.HostPort, error) {
+	nodeIP := ""
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == v1.NodeInternalIP {
+			nodeIP = addr.Address
+		}
+	}
+	d.topoLock.RLock()
+	hp, found := d.topo.FindNode(node.Name, nodeIP)
+	if port := node.Labels[topology.HostPortLabel]; port != "" && port != hp.Port {
+		hp, found = topology.HostPort{Port: port, Node: node.Name}, true
+		for _, configured := range d.topo.HostPorts() {
+			if configured.Port == port {
+				hp.Pool = configured.Pool
+			}
+		}
+	}
+	d.topoLock.RUnlock()
+	if !found {
+		return topology.HostPort{}, fmt.Errorf("node %s is cabled to no host port", node.Name)
+	}
+	if pool := node.Labels[topology.PoolLabel]; pool != "" {
+		hp.Pool = pool
+	}
+	if hp.Pool == "" {
+		hp.Pool = topology.DefaultPoolName
+	}
+	return hp, nil
+}
+
+// Groups the devices by pool. A device belongs to the pool listing it in the topology, a device listed in no pool
+// belongs to the only pool, and is left out if there are several pools since it may not be cabled to the node.
+func (d *Driver) poolDevices(devices []inter.DevicePair) (map[string][]inter.DevicePair, error) {
+	pools := sets.New[string]()
+	d.topoLock.RLock()
+	configured := d.topo.DevicePools()
+	for _, pool := range d.topo.Pools {
+		pools.Insert(pool.Name)
+	}
+	d.topoLock.RUnlock()
+	nodes, err := d.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if hp, err := d.nodeHostPort(node); err == nil {
+			pools.Insert(hp.Pool)
+		}
+	}
+
+	poolDevices := make(map[string][]inter.DevicePair)
+	for _, dev := range devices {
+		switch {
+		case configured[dev.DevID] != "":
+			poolDevices[configured[dev.DevID]] = append(poolDevices[configured[dev.DevID]], dev)
+		case pools.Len() == 1:
+			pool := pools.UnsortedList()[0]
+			poolDevices[pool] = append(poolDevices[pool], dev)
+		}
+	}
+	return poolDevices, nil
+}
+
+// GetClassParameters accepts the classes without parameters only
+func (d *Driver) GetClassParameters(ctx context.Context, class *resourcev1alpha2.ResourceClass) (interface{}, error) {
+	if class.ParametersRef != nil {
+		return nil, fmt.Errorf("class %s: parameters are not supported", class.Name)
+	}
+	return nil, nil
+}
+
+// GetClaimParameters reads the count key of the ConfigMap referenced by the claim
+func (d *Driver) GetClaimParameters(ctx context.Context, claim *resourcev1alpha2.ResourceClaim, class *resourcev1alpha2.ResourceClass, classParameters interface{}) (interface{}, error) {
+	ref := claim.Spec.ParametersRef
+	if ref == nil {
+		return &ClaimParameters{Count: 1}, nil
+	}
+	if ref.APIGroup != "" || ref.Kind != "ConfigMap" {
+		return nil, fmt.Errorf("claim parameters of kind %s.%s are not supported, use a ConfigMap", ref.Kind, ref.APIGroup)
+	}
+	cm, err := d.clientset.CoreV1().ConfigMaps(claim.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(cm.Data["count"])
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("ConfigMap %s/%s: invalid GPU count %q", claim.Namespace, ref.Name, cm.Data["count"])
+	}
+	return &ClaimParameters{Count: count}, nil
+}
+
+// Allocate reserves and attaches the unassigned devices of the claim to the host port of the selected node.
+// The claims are allocated for the node of their pod only, so immediate allocation is rejected.
+func (d *Driver) Allocate(ctx context.Context, claim *resourcev1alpha2.ResourceClaim, claimParameters interface{}, class *resourcev1alpha2.ResourceClass, classParameters interface{}, selectedNode string) (*resourcev1alpha2.AllocationResult, error) {
+	if selectedNode == "" {
+		return nil, fmt.Errorf("claim %s/%s: immediate allocation is not supported, use allocationMode WaitForFirstConsumer", claim.Namespace, claim.Name)
+	}
+	count := claimParameters.(*ClaimParameters).Count
+	hp, err := d.hostPort(selectedNode)
+	if err != nil {
+		return nil, err
+	}
+	port := hp.Port
+	logger := klog.FromContext(ctx).WithValues("claim", klog.KObj(claim), "node", selectedNode, "hostport", port, "pool", hp.Pool)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// The devices of an allocation interrupted by a restart are detached first
+	if err := d.release(ctx, claim.UID, nil); err != nil {
+		return nil, err
+	}
+	free, err := d.freeDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	poolDevices, err := d.poolDevices(free)
+	if err != nil {
+		return nil, err
+	}
+	free = poolDevices[hp.Pool]
+	if len(free) < count {
+		return nil, fmt.Errorf("claim %s/%s: %d GPUs requested, %d unassigned GPUs left in pool %s", claim.Namespace, claim.Name, count, len(free), hp.Pool)
+	}
+
+	handle := &ResourceHandle{Node: selectedNode, HostPort: port}
+	for _, dev := range free[:count] {
+		handle.Devices = append(handle.Devices, Device{DevID: dev.DevID, UUID: dev.UUID})
+	}
+	if err := d.reserved.reserve(ctx, claim.UID, handle.DevIDs()); err != nil {
+		return nil, fmt.Errorf("failed to reserve devices %v: %v", handle.DevIDs(), err)
+	}
+	for _, dev := range handle.Devices {
+		if err := d.pool.Assign(ctx, port, dev.DevID); err != nil {
+			if releaseErr := d.release(ctx, claim.UID, nil); releaseErr != nil {
+				logger.Error(releaseErr, "Failed to roll back the allocation")
+			}
+			return nil, fmt.Errorf("failed to attach device %s to host port %s: %v", dev.DevID, port, err)
+		}
+	}
+	logger.Info("Allocated GPUs", "devids", handle.DevIDs())
+
+	data, err := handle.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return &resourcev1alpha2.AllocationResult{
+		ResourceHandles: []resourcev1alpha2.ResourceHandle{{DriverName: DriverName, Data: data}},
+		AvailableOnNodes: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+			MatchFields: []v1.NodeSelectorRequirement{{Key: "metadata.name", Operator: v1.NodeSelectorOpIn, Values: []string{selectedNode}}},
+		}}},
+		Shareable: false,
+	}, nil
+}
+
+// Deallocate detaches the devices of the claim into the unassigned state and releases them
+func (d *Driver) Deallocate(ctx context.Context, claim *resourcev1alpha2.ResourceClaim) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var handle *ResourceHandle
+	if claim.Status.Allocation != nil {
+		for _, h := range claim.Status.Allocation.ResourceHandles {
+			if h.DriverName != DriverName {
+				continue
+			}
+			var err error
+			if handle, err = DecodeResourceHandle(h.Data); err != nil {
+				klog.FromContext(ctx).Error(err, "Ignoring the resource handle of the claim", "claim", klog.KObj(claim))
+			}
+		}
+	}
+	if err := d.release(ctx, claim.UID, handle); err != nil {
+		return err
+	}
+	klog.FromContext(ctx).Info("Deallocated GPUs", "claim", klog.KObj(claim))
+	return nil
+}
+
+// Detaches the devices reserved for the claim, and the devices of its handle still attached to its host port, then releases them.
+// Must be called with the lock held.
+func (d *Driver) release(ctx context.Context, uid types.UID, handle *ResourceHandle) error {
+	devIDs, err := d.reserved.claimDevices(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if handle != nil {
+		// Without the reservation, which may have been lost, the device may have been given to someone else meanwhile
+		devices, err := d.pool.GetAllResource(ctx)
+		if err != nil {
+			return err
+		}
+		attached := make(map[string]string, len(devices))
+		for _, dev := range devices {
+			attached[dev.DevID] = dev.HostPort
+		}
+		reserved := make(map[string]bool, len(devIDs))
+		for _, dev := range devIDs {
+			reserved[dev] = true
+		}
+		for _, dev := range handle.DevIDs() {
+			if !reserved[dev] && attached[dev] == handle.HostPort {
+				devIDs = append(devIDs, dev)
+			}
+		}
+	}
+	if len(devIDs) == 0 {
+		return nil
+	}
+
+	sort.Strings(devIDs)
+	for _, dev := range devIDs {
+		if err := d.pool.Unassign(ctx, dev); err != nil {
+			return fmt.Errorf("failed to detach device %s: %v", dev, err)
+		}
+	}
+	klog.FromContext(ctx).V(2).Info("Detached the devices of the claim", "claimUID", uid, "devids", devIDs)
+	return d.reserved.release(ctx, uid)
+}
+
+// Returns the unassigned devices which are not reserved, sorted by DevID
+func (d *Driver) freeDevices(ctx context.Context) ([]inter.DevicePair, error) {
+	devices, err := d.pool.GetAllResource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := d.reserved.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var free []inter.DevicePair
+	for _, dev := range devices {
+		if _, ok := reserved[dev.DevID]; dev.HostPort == "" && !ok {
+			free = append(free, dev)
+		}
+	}
+	sort.Slice(free, func(i, j int) bool { return free[i].DevID < free[j].DevID })
+	return free, nil
+}
+
+// UnsuitableNodes marks the nodes cabled to no host port, and the nodes whose pool cannot satisfy all the claims of the pod
+func (d *Driver) UnsuitableNodes(ctx context.Context, pod *v1.Pod, claims []*controller.ClaimAllocation, potentialNodes []string) error {
+	d.lock.Lock()
+	free, err := d.freeDevices(ctx)
+	d.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	poolDevices, err := d.poolDevices(free)
+	if err != nil {
+		return err
+	}
+
+	requested := 0
+	for _, ca := range claims {
+		requested += ca.ClaimParameters.(*ClaimParameters).Count
+	}
+	var unsuitable []string
+	for _, nodeName := range potentialNodes {
+		if hp, err := d.hostPort(nodeName); err != nil || requested > len(poolDevices[hp.Pool]) {
+			unsuitable = append(unsuitable, nodeName)
+		}
+	}
+	for _, ca := range claims {
+		ca.UnsuitableNodes = unsuitable
+	}
+	unassigned := make(map[string]int, len(poolDevices))
+	for pool, devices := range poolDevices {
+		unassigned[pool] = len(devices)
+	}
+	klog.FromContext(ctx).V(2).Info("Checked the potential nodes", "pod", klog.KObj(pod), "requested", requested, "unassigned", unassigned, "unsuitable", unsuitable)
+	return nil
+}
@@ -0,0 +1,75 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/dynamic-resource-allocation/controller"
+
+	"kubecomp/topology"
+)
+
+// The unassigned GPUs are counted in the pool of each node, a GPU listed in no pool is given to no node
+func TestUnsuitableNodesByPool(t *testing.T) {
+	pool := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"devid": "1", "hostport": ""}, {"devid": "2", "hostport": ""}, {"devid": "3", "hostport": ""},
+			{"devid": "4", "hostport": ""}, {"devid": "5", "hostport": "B"}]`))
+	}))
+	defer pool.Close()
+	topo, err := topology.Parse([]byte(`
+apiVersion: kubecomp/v1alpha1
+kind: Topology
+pools:
+- name: pool1
+  hostPorts:
+  - {port: A, node: a}
+  devices: ["1"]
+- name: pool2
+  hostPorts:
+  - {port: B, node: b}
+  devices: ["2", "3"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	topo.Endpoints.Resources = pool.URL
+
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "c", Labels: map[string]string{topology.HostPortLabel: "C", topology.PoolLabel: "pool2"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "d"}},
+	} {
+		if err := nodeIndexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := New(fake.NewSimpleClientset(), corelisters.NewNodeLister(nodeIndexer), topo, "", "", "kubecomp", "pool-dra-reserved")
+
+	tests := []struct {
+		requested      int
+		wantUnsuitable []string
+	}{
+		{requested: 1, wantUnsuitable: []string{"d"}},
+		{requested: 2, wantUnsuitable: []string{"a", "d"}},
+		{requested: 3, wantUnsuitable: []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		claims := []*controller.ClaimAllocation{{ClaimParameters: &ClaimParameters{Count: tt.requested}}}
+		if err := d.UnsuitableNodes(context.Background(), &v1.Pod{}, claims, []string{"a", "b", "c", "d"}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(claims[0].UnsuitableNodes, tt.wantUnsuitable) {
+			t.Errorf("UnsuitableNodes() for %d GPUs = %v, want %v", tt.requested, claims[0].UnsuitableNodes, tt.wantUnsuitable)
+		}
+	}
+}
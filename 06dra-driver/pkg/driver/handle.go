@@ -0,0 +1,50 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// DriverName is the name of the driver in the ResourceClasses and the allocation results
+	DriverName string = "gpu.falcon.com"
+	// CDIKind is the vendor and class of the CDI devices handed to the containers
+	CDIKind string = "falcon.com/gpu"
+)
+
+// ResourceHandle is the allocation of a claim, passed by the controller to the kubelet plugin through the claim
+type ResourceHandle struct {
+	Node     string   `json:"node"`
+	HostPort string   `json:"hostPort"`
+	Devices  []Device `json:"devices"`
+}
+
+type Device struct {
+	DevID string `json:"devID"`
+	UUID  string `json:"uuid"`
+}
+
+func (h *ResourceHandle) Encode() (string, error) {
+	buf, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func DecodeResourceHandle(data string) (*ResourceHandle, error) {
+	h := &ResourceHandle{}
+	if err := json.Unmarshal([]byte(data), h); err != nil {
+		return nil, fmt.Errorf("invalid resource handle: %v", err)
+	}
+	return h, nil
+}
+
+// DevIDs returns the DevIDs of the allocated devices
+func (h *ResourceHandle) DevIDs() []string {
+	devIDs := make([]string, len(h.Devices))
+	for i, dev := range h.Devices {
+		devIDs[i] = dev.DevID
+	}
+	return devIDs
+}
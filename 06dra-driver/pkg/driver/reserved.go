@@ -0,0 +1,92 @@
+package driver
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// reservations publishes the devices reserved for the claims in a ConfigMap, keyed by DevID with the UID of the claim.
+// A device is reserved before it is attached and released after it is detached, so reconfig-mgr never moves it
+// and the device plugin never advertises it in between.
+type reservations struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// Returns the reserved devices, the ConfigMap is created on the first reservation
+func (r *reservations) get(ctx context.Context) (map[string]string, error) {
+	cm, err := r.client.CoreV1().ConfigMaps(r.namespace).Get(ctx, r.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cm.Data == nil {
+		return map[string]string{}, nil
+	}
+	return cm.Data, nil
+}
+
+// Returns the devices reserved for the claim
+func (r *reservations) claimDevices(ctx context.Context, uid types.UID) ([]string, error) {
+	data, err := r.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var devIDs []string
+	for dev, owner := range data {
+		if owner == string(uid) {
+			devIDs = append(devIDs, dev)
+		}
+	}
+	return devIDs, nil
+}
+
+func (r *reservations) reserve(ctx context.Context, uid types.UID, devIDs []string) error {
+	return r.update(ctx, func(data map[string]string) {
+		for _, dev := range devIDs {
+			data[dev] = string(uid)
+		}
+	})
+}
+
+func (r *reservations) release(ctx context.Context, uid types.UID) error {
+	return r.update(ctx, func(data map[string]string) {
+		for dev, owner := range data {
+			if owner == string(uid) {
+				delete(data, dev)
+			}
+		}
+	})
+}
+
+// Applies the change to the ConfigMap, a conflicting update fails and the claim is retried by the controller
+func (r *reservations) update(ctx context.Context, change func(map[string]string)) error {
+	cms := r.client.CoreV1().ConfigMaps(r.namespace)
+	cm, err := cms.Get(ctx, r.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		data := map[string]string{}
+		change(data)
+		_, err = cms.Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: r.name, Namespace: r.namespace},
+			Data:       data,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	change(cm.Data)
+	_, err = cms.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,141 @@
+package inter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultMoveEndpoint is the endpoint of reconfig-mgr attaching and detaching the devices
+const DefaultMoveEndpoint string = "http://reconfig-mgr.kubecomp.svc.cluster.local:8080/move"
+
+// FalconInterface reads the resource pool through its API, and reconfigures it through reconfig-mgr,
+// which moves the devices with its fencing token and never while they are being reconfigured
+type FalconInterface struct {
+	endpointLock        sync.RWMutex
+	getResourceEndpoint string
+	moveEndpoint        string
+	tokenFile           string // service account token authenticating the moves to reconfig-mgr
+	client              *http.Client
+}
+
+type DevicePair struct {
+	DevID    string
+	UUID     string
+	HostPort string // empty while the device is unassigned
+}
+
+// NewDevInterface returns the interface, the moveEndpoint of reconfig-mgr may be empty if the devices are only read
+func NewDevInterface(getResourceEndpoint string, moveEndpoint string) *FalconInterface {
+	return &FalconInterface{
+		getResourceEndpoint: getResourceEndpoint,
+		moveEndpoint:        moveEndpoint,
+		client:              &http.Client{},
+	}
+}
+
+// SetTokenFile authenticates the moves with the service account token of the file, read at every move since the
+// kubelet rotates it
+func (fi *FalconInterface) SetTokenFile(tokenFile string) {
+	fi.tokenFile = tokenFile
+}
+
+// Points the interface to another endpoint of the pool, used when the topology is reloaded
+func (fi *FalconInterface) SetResourceEndpoint(getResourceEndpoint string) {
+	fi.endpointLock.Lock()
+	defer fi.endpointLock.Unlock()
+	fi.getResourceEndpoint = getResourceEndpoint
+}
+
+// Returns the current endpoint to get the resources
+func (fi *FalconInterface) resourceEndpoint() string {
+	fi.endpointLock.RLock()
+	defer fi.endpointLock.RUnlock()
+	return fi.getResourceEndpoint
+}
+
+func (fi *FalconInterface) GetAllResource(ctx context.Context) ([]DevicePair, error) {
+	body, err := fi.sendRequest(ctx, http.MethodGet, fi.resourceEndpoint(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parses the result
+	var result []map[string]string
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("error unmarshalling JSON: %v", err)
+	}
+
+	// Returns all devices
+	var devices []DevicePair
+	for _, res := range result {
+		devices = append(devices, DevicePair{
+			DevID:    res["devid"],
+			UUID:     res["uuid"],
+			HostPort: res["hostport"],
+		})
+	}
+	return devices, nil
+}
+
+// Assign attaches an unassigned device to the host port, reconfig-mgr rejects a device which is already attached
+func (fi *FalconInterface) Assign(ctx context.Context, hostPort string, devid string) error {
+	param := fmt.Sprintf(`{"devid" : "%s", "hostport" : "%s", "from_hostport" : ""}`, devid, hostPort)
+	return fi.move(ctx, param)
+}
+
+// Unassign detaches the device into the unassigned state, detaching an unassigned device succeeds
+func (fi *FalconInterface) Unassign(ctx context.Context, devid string) error {
+	param := fmt.Sprintf(`{"devid" : "%s", "hostport" : ""}`, devid)
+	return fi.move(ctx, param)
+}
+
+// Posts the move to reconfig-mgr, which lets the driver move the devices it reserved since its service account may
+// update the ConfigMap of the reservations
+func (fi *FalconInterface) move(ctx context.Context, param string) error {
+	var token string
+	if fi.tokenFile != "" {
+		data, err := os.ReadFile(fi.tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the service account token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	_, err := fi.sendRequest(ctx, http.MethodPost, fi.moveEndpoint, token, strings.NewReader(param))
+	return err
+}
+
+func (fi *FalconInterface) sendRequest(ctx context.Context, method, url string, token string, payload io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	// Performs the request
+	res, err := fi.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	// Reads and return the response body
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if res.StatusCode >= 400 {
+		return nil, fmt.Errorf("HTTP request error: %s", string(body))
+	}
+
+	return body, nil
+}
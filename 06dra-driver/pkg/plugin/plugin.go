@@ -0,0 +1,119 @@
+// Package plugin is the kubelet plugin of the DRA driver. It prepares the claims allocated to its node by handing
+// their devices to the containers through a CDI spec, with the same environment as the device plugin.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1alpha2"
+
+	"dra-driver/pkg/driver"
+	"dra-driver/pkg/inter"
+)
+
+// DefaultCDIRoot is the directory the container runtime reads the CDI specs from
+const DefaultCDIRoot string = "/var/run/cdi"
+
+// NodeServer prepares and unprepares the claims of the pods of its node
+type NodeServer struct {
+	nodeName string
+	cdiRoot  string
+	pool     *inter.FalconInterface
+}
+
+var _ drapbv1.NodeServer = &NodeServer{}
+
+func NewNodeServer(nodeName string, cdiRoot string, pool *inter.FalconInterface) *NodeServer {
+	return &NodeServer{nodeName: nodeName, cdiRoot: cdiRoot, pool: pool}
+}
+
+// The CDI spec of a claim, which holds a single device named after the claim UID
+type cdiSpec struct {
+	Version string      `json:"cdiVersion"`
+	Kind    string      `json:"kind"`
+	Devices []cdiDevice `json:"devices"`
+}
+
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	Env []string `json:"env"`
+}
+
+// Returns the path of the CDI spec of the claim
+func (s *NodeServer) specPath(claimUID string) string {
+	return filepath.Join(s.cdiRoot, strings.ReplaceAll(driver.CDIKind, "/", "-")+"_"+claimUID+".json")
+}
+
+// NodePrepareResource checks that the devices of the claim are attached to the host port of the node, and writes their CDI spec
+func (s *NodeServer) NodePrepareResource(ctx context.Context, req *drapbv1.NodePrepareResourceRequest) (*drapbv1.NodePrepareResourceResponse, error) {
+	logger := klog.FromContext(ctx).WithValues("claim", klog.KRef(req.GetNamespace(), req.GetClaimName()), "node", s.nodeName)
+	handle, err := driver.DecodeResourceHandle(req.GetResourceHandle())
+	if err != nil {
+		return nil, err
+	}
+	if handle.Node != s.nodeName {
+		return nil, fmt.Errorf("claim %s/%s is allocated to node %s", req.GetNamespace(), req.GetClaimName(), handle.Node)
+	}
+
+	// Kubelet retries the preparation until the controller has attached every device
+	devices, err := s.pool.GetAllResource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attached := make(map[string]string, len(devices))
+	for _, dev := range devices {
+		attached[dev.DevID] = dev.HostPort
+	}
+	uuids := make([]string, len(handle.Devices))
+	for i, dev := range handle.Devices {
+		if attached[dev.DevID] != handle.HostPort {
+			return nil, fmt.Errorf("device %s is not attached to host port %s", dev.DevID, handle.HostPort)
+		}
+		uuids[i] = dev.UUID
+	}
+
+	spec := cdiSpec{
+		Version: "0.3.0",
+		Kind:    driver.CDIKind,
+		Devices: []cdiDevice{{
+			Name: req.GetClaimUid(),
+			ContainerEdits: cdiContainerEdits{Env: []string{
+				"DISAG_DEVICES=" + strings.Join(handle.DevIDs(), ","),
+				"NVIDIA_VISIBLE_DEVICES=" + strings.Join(uuids, ","),
+			}},
+		}},
+	}
+	buf, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	// The spec is renamed into place, so that the runtime never reads it half written
+	path := s.specPath(req.GetClaimUid())
+	if err := os.WriteFile(path+".tmp", buf, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return nil, err
+	}
+	logger.Info("Prepared the claim", "hostport", handle.HostPort, "devids", handle.DevIDs())
+	return &drapbv1.NodePrepareResourceResponse{CdiDevices: []string{driver.CDIKind + "=" + req.GetClaimUid()}}, nil
+}
+
+// NodeUnprepareResource removes the CDI spec of the claim, the controller detaches its devices once it is deallocated
+func (s *NodeServer) NodeUnprepareResource(ctx context.Context, req *drapbv1.NodeUnprepareResourceRequest) (*drapbv1.NodeUnprepareResourceResponse, error) {
+	if err := os.Remove(s.specPath(req.GetClaimUid())); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	klog.FromContext(ctx).Info("Unprepared the claim", "claim", klog.KRef(req.GetNamespace(), req.GetClaimName()), "node", s.nodeName)
+	return &drapbv1.NodeUnprepareResourceResponse{}, nil
+}
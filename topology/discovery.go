@@ -0,0 +1,43 @@
+package topology
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Labels set by the device plugin on its node, from which reconfig-mgr and the scheduler derive the topology
+	HostPortLabel string = "falcon.com/host-port"
+	PoolLabel     string = "falcon.com/pool"
+
+	// File on the host telling the host port the node is cabled to, written when the node is cabled
+	DefaultHostPortPath string = "/etc/kubecomp/hostport.yaml"
+)
+
+// LocalHostPort is the content of the host port file
+type LocalHostPort struct {
+	Port string `yaml:"port"`
+	Pool string `yaml:"pool,omitempty"` // default pool1
+}
+
+// ReadHostPortFile reads the host port file of the node.
+// The error wraps os.ErrNotExist if the file is missing, so that the caller can fall back to the topology.
+func ReadHostPortFile(path string) (*LocalHostPort, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	local := &LocalHostPort{}
+	if err := yaml.Unmarshal(buf, local); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if local.Port == "" {
+		return nil, fmt.Errorf("%s: port: required", path)
+	}
+	if local.Pool == "" {
+		local.Pool = DefaultPoolName
+	}
+	return local, nil
+}
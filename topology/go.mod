@@ -0,0 +1,10 @@
+module kubecomp/topology
+
+go 1.20
+
+require (
+	github.com/fsnotify/fsnotify v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.3.0 // indirect
@@ -0,0 +1,289 @@
+// Package topology loads the topology config shared by the device plugin, reconfig-mgr and the DRA driver:
+// the resource pool endpoints, the pools and the host port, node and IP cabled to each pool.
+package topology
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	APIVersion  string = "kubecomp/v1alpha1"
+	Kind        string = "Topology"
+	DefaultPath string = "/etc/kubernetes/topology.yaml"
+
+	DefaultResourcesEndpoint  string = "http://resource-pool-service.kubecomp.svc.cluster.local:8000/resources"
+	DefaultAllocationEndpoint string = "http://resource-pool-service.kubecomp.svc.cluster.local:8000/allocation"
+	DefaultPoolName           string = "pool1"
+)
+
+// Topology is the versioned topology config
+type Topology struct {
+	APIVersion string    `yaml:"apiVersion"`
+	Kind       string    `yaml:"kind"`
+	Endpoints  Endpoints `yaml:"endpoints"`
+	Pools      []Pool    `yaml:"pools"`
+}
+
+// Endpoints of the resource pool API
+type Endpoints struct {
+	Resources  string `yaml:"resources"`  // GET the devices and the host ports they are attached to
+	Allocation string `yaml:"allocation"` // POST and DELETE the attachment of a device
+}
+
+// Pool is a resource pool whose devices can be attached to any of its host ports
+type Pool struct {
+	Name      string     `yaml:"name"`
+	HostPorts []HostPort `yaml:"hostPorts"`
+	Devices   []string   `yaml:"devices,omitempty"` // IDs of the devices of the pool, so that a detached device keeps its pool
+}
+
+// HostPort is a port of the pool and the node cabled to it
+type HostPort struct {
+	Port   string `yaml:"port"`
+	Node   string `yaml:"node"`
+	IP     string `yaml:"ip,omitempty"`     // internal IP of the node, matched when the node name is unknown
+	Switch string `yaml:"switch,omitempty"` // switch the port is behind, used by the prefer-same-switch strategy
+	Pool   string `yaml:"-"`
+}
+
+// Load reads, defaults and validates the topology file.
+// The error wraps os.ErrNotExist if the file is missing, so that the caller can fall back to the legacy keys.
+func Load(path string) (*Topology, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := Parse(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Parse decodes, defaults and validates a topology. Unknown fields are rejected, so that typos do not go unnoticed.
+func Parse(buf []byte) (*Topology, error) {
+	t := &Topology{}
+	decoder := yaml.NewDecoder(bytes.NewReader(buf))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(t); err != nil {
+		return nil, fmt.Errorf("invalid topology: %v", err)
+	}
+	t.SetDefaults()
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Default returns the topology used without a valid file: the default endpoints of the resource pool service
+// and no pool, the nodes then advertise their host ports through labels
+func Default() *Topology {
+	t := &Topology{}
+	t.SetDefaults()
+	return t
+}
+
+// SetDefaults fills the version, the endpoints of the resource pool service and the pool names which are not given
+func (t *Topology) SetDefaults() {
+	if t.APIVersion == "" {
+		t.APIVersion = APIVersion
+	}
+	if t.Kind == "" {
+		t.Kind = Kind
+	}
+	if t.Endpoints.Resources == "" {
+		t.Endpoints.Resources = DefaultResourcesEndpoint
+	}
+	if t.Endpoints.Allocation == "" {
+		t.Endpoints.Allocation = DefaultAllocationEndpoint
+	}
+	for i := range t.Pools {
+		if t.Pools[i].Name == "" {
+			t.Pools[i].Name = fmt.Sprintf("pool%d", i+1)
+		}
+		for j := range t.Pools[i].HostPorts {
+			t.Pools[i].HostPorts[j].Pool = t.Pools[i].Name
+		}
+	}
+}
+
+// Validate returns every problem of the topology at once, each prefixed by the path of the field.
+// The pools and host ports may be left out, the nodes then advertise their host ports through labels.
+func (t *Topology) Validate() error {
+	var errs []string
+	invalid := func(field string, format string, args ...interface{}) {
+		errs = append(errs, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t.APIVersion != APIVersion {
+		invalid("apiVersion", "unsupported version %q, expected %q", t.APIVersion, APIVersion)
+	}
+	if t.Kind != Kind {
+		invalid("kind", "unsupported kind %q, expected %q", t.Kind, Kind)
+	}
+	pools := make(map[string]string)
+	ports := make(map[string]string)
+	nodes := make(map[string]string)
+	ips := make(map[string]string)
+	devices := make(map[string]string)
+	for i, pool := range t.Pools {
+		poolField := fmt.Sprintf("pools[%d]", i)
+		if other, ok := pools[pool.Name]; ok {
+			invalid(poolField+".name", "duplicate pool %q, also in %s", pool.Name, other)
+		}
+		pools[pool.Name] = poolField
+
+		for j, hp := range pool.HostPorts {
+			field := fmt.Sprintf("%s.hostPorts[%d]", poolField, j)
+			if hp.Port == "" {
+				invalid(field+".port", "required")
+			} else if other, ok := ports[hp.Port]; ok {
+				invalid(field+".port", "duplicate host port %q, also in %s", hp.Port, other)
+			}
+			ports[hp.Port] = field
+			if hp.Node == "" {
+				invalid(field+".node", "required")
+			} else if other, ok := nodes[hp.Node]; ok {
+				invalid(field+".node", "node %q is cabled to a single host port, also in %s", hp.Node, other)
+			}
+			nodes[hp.Node] = field
+			if hp.IP != "" {
+				if other, ok := ips[hp.IP]; ok {
+					invalid(field+".ip", "duplicate IP %q, also in %s", hp.IP, other)
+				}
+				ips[hp.IP] = field
+			}
+		}
+		for j, dev := range pool.Devices {
+			field := fmt.Sprintf("%s.devices[%d]", poolField, j)
+			if dev == "" {
+				invalid(field, "required")
+			} else if other, ok := devices[dev]; ok {
+				invalid(field, "duplicate device %q, also in %s", dev, other)
+			}
+			devices[dev] = field
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid topology:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// HostPorts returns the host ports of all pools
+func (t *Topology) HostPorts() []HostPort {
+	var hostPorts []HostPort
+	for _, pool := range t.Pools {
+		hostPorts = append(hostPorts, pool.HostPorts...)
+	}
+	return hostPorts
+}
+
+// NodePorts maps each node name to its host port
+func (t *Topology) NodePorts() map[string]string {
+	nodePorts := make(map[string]string)
+	for _, hp := range t.HostPorts() {
+		nodePorts[hp.Node] = hp.Port
+	}
+	return nodePorts
+}
+
+// DevicePools maps each device listed in a pool to the pool
+func (t *Topology) DevicePools() map[string]string {
+	devicePools := make(map[string]string)
+	for _, pool := range t.Pools {
+		for _, dev := range pool.Devices {
+			devicePools[dev] = pool.Name
+		}
+	}
+	return devicePools
+}
+
+// PortSwitches maps each host port behind a switch to the switch
+func (t *Topology) PortSwitches() map[string]string {
+	portSwitches := make(map[string]string)
+	for _, hp := range t.HostPorts() {
+		if hp.Switch != "" {
+			portSwitches[hp.Port] = hp.Switch
+		}
+	}
+	return portSwitches
+}
+
+// FindNode returns the host port of the node given by its name, or by its IP if no host port lists the name
+func (t *Topology) FindNode(name string, ip string) (HostPort, bool) {
+	for _, hp := range t.HostPorts() {
+		if name != "" && hp.Node == name {
+			return hp, true
+		}
+	}
+	for _, hp := range t.HostPorts() {
+		if ip != "" && hp.IP == ip {
+			return hp, true
+		}
+	}
+	return HostPort{}, false
+}
+
+// FromLegacy converts the comma-separated lists of the unversioned config, matched by position, into a topology.
+// All host ports are put in a single pool. The nodes are given by name, by IP or both.
+func FromLegacy(resourcesEndpoint string, allocationEndpoint string, nodes string, ips string, ports string, switches string) (*Topology, error) {
+	split := func(list string) []string {
+		if strings.TrimSpace(list) == "" {
+			return nil
+		}
+		items := strings.Split(list, ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		return items
+	}
+	portList, nodeList, ipList, switchList := split(ports), split(nodes), split(ips), split(switches)
+
+	var errs []string
+	for _, key := range []struct {
+		name string
+		list []string
+	}{{"node_names", nodeList}, {"local_ips", ipList}, {"port_switches", switchList}} {
+		if key.list != nil && len(key.list) != len(portList) {
+			errs = append(errs, fmt.Sprintf("%s: %d values for %d host_ports", key.name, len(key.list), len(portList)))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid legacy config:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	pool := Pool{Name: DefaultPoolName}
+	for i, port := range portList {
+		hp := HostPort{Port: port}
+		if nodeList != nil {
+			hp.Node = nodeList[i]
+		}
+		if ipList != nil {
+			hp.IP = ipList[i]
+			if hp.Node == "" {
+				hp.Node = hp.IP // only the IP is known to the device plugin
+			}
+		}
+		if switchList != nil {
+			hp.Switch = switchList[i]
+		}
+		pool.HostPorts = append(pool.HostPorts, hp)
+	}
+
+	t := &Topology{
+		Endpoints: Endpoints{Resources: resourcesEndpoint, Allocation: allocationEndpoint},
+		Pools:     []Pool{pool},
+	}
+	t.SetDefaults()
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,220 @@
+package topology
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		want        []HostPort
+		wantDevices map[string]string
+		wantErr     []string
+	}{
+		{
+			name: "pools and host ports",
+			yaml: `
+apiVersion: kubecomp/v1alpha1
+kind: Topology
+pools:
+- name: pool1
+  hostPorts:
+  - port: "1"
+    node: node1
+    ip: 10.0.0.1
+    switch: s1
+- hostPorts:
+  - port: "2"
+    node: node2
+  devices: ["3", "4"]
+`,
+			want: []HostPort{
+				{Port: "1", Node: "node1", IP: "10.0.0.1", Switch: "s1", Pool: "pool1"},
+				{Port: "2", Node: "node2", Pool: "pool2"},
+			},
+			wantDevices: map[string]string{"3": "pool2", "4": "pool2"},
+		},
+		{
+			name: "no pool, the nodes advertise their host ports through labels",
+			yaml: "apiVersion: kubecomp/v1alpha1\nkind: Topology\n",
+		},
+		{
+			name:    "unknown field",
+			yaml:    "apiVersion: kubecomp/v1alpha1\nkind: Topology\npool: []\n",
+			wantErr: []string{"field pool not found"},
+		},
+		{
+			name:    "unsupported version",
+			yaml:    "apiVersion: kubecomp/v2\nkind: Topology\n",
+			wantErr: []string{`apiVersion: unsupported version "kubecomp/v2"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topo, err := Parse([]byte(tt.yaml))
+			if len(tt.wantErr) > 0 {
+				if err == nil {
+					t.Fatalf("Parse() = %+v, want an error", topo)
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("Parse() error = %v, want %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := topo.HostPorts(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HostPorts() = %+v, want %+v", got, tt.want)
+			}
+			if got := topo.DevicePools(); len(got) != len(tt.wantDevices) || (len(got) > 0 && !reflect.DeepEqual(got, tt.wantDevices)) {
+				t.Errorf("DevicePools() = %v, want %v", got, tt.wantDevices)
+			}
+			if topo.Endpoints.Resources != DefaultResourcesEndpoint || topo.Endpoints.Allocation != DefaultAllocationEndpoint {
+				t.Errorf("Endpoints = %+v, want the defaults", topo.Endpoints)
+			}
+		})
+	}
+}
+
+// Every problem is reported at once with the path of its field
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pools   []Pool
+		wantErr []string
+	}{
+		{
+			name: "valid",
+			pools: []Pool{
+				{Name: "pool1", HostPorts: []HostPort{{Port: "1", Node: "node1"}}},
+				{Name: "pool2", HostPorts: []HostPort{{Port: "2", Node: "node2"}}},
+			},
+		},
+		{
+			name: "duplicates",
+			pools: []Pool{
+				{Name: "pool1", HostPorts: []HostPort{{Port: "1", Node: "node1", IP: "10.0.0.1"}}},
+				{Name: "pool1", HostPorts: []HostPort{{Port: "1", Node: "node1", IP: "10.0.0.1"}}},
+			},
+			wantErr: []string{
+				`pools[1].name: duplicate pool "pool1", also in pools[0]`,
+				`pools[1].hostPorts[0].port: duplicate host port "1", also in pools[0].hostPorts[0]`,
+				`pools[1].hostPorts[0].node: node "node1" is cabled to a single host port, also in pools[0].hostPorts[0]`,
+				`pools[1].hostPorts[0].ip: duplicate IP "10.0.0.1", also in pools[0].hostPorts[0]`,
+			},
+		},
+		{
+			name: "device in two pools",
+			pools: []Pool{
+				{Name: "pool1", Devices: []string{"1", "2"}},
+				{Name: "pool2", Devices: []string{"2", ""}},
+			},
+			wantErr: []string{
+				`pools[1].devices[0]: duplicate device "2", also in pools[0].devices[1]`,
+				"pools[1].devices[1]: required",
+			},
+		},
+		{
+			name:  "missing port and node",
+			pools: []Pool{{Name: "pool1", HostPorts: []HostPort{{}}}},
+			wantErr: []string{
+				"pools[0].hostPorts[0].port: required",
+				"pools[0].hostPorts[0].node: required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topo := &Topology{Pools: tt.pools}
+			topo.SetDefaults()
+			err := topo.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate() = nil, want an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFromLegacy(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    string
+		ips      string
+		ports    string
+		switches string
+		want     []HostPort
+		wantErr  string
+	}{
+		{
+			name:     "nodes by name",
+			nodes:    "node1, node2",
+			ports:    "1,2",
+			switches: "s1,s2",
+			want: []HostPort{
+				{Port: "1", Node: "node1", Switch: "s1", Pool: DefaultPoolName},
+				{Port: "2", Node: "node2", Switch: "s2", Pool: DefaultPoolName},
+			},
+		},
+		{
+			name:  "nodes by IP only",
+			ips:   "10.0.0.1,10.0.0.2",
+			ports: "1,2",
+			want: []HostPort{
+				{Port: "1", Node: "10.0.0.1", IP: "10.0.0.1", Pool: DefaultPoolName},
+				{Port: "2", Node: "10.0.0.2", IP: "10.0.0.2", Pool: DefaultPoolName},
+			},
+		},
+		{
+			name:    "lists of different lengths",
+			nodes:   "node1",
+			ports:   "1,2",
+			wantErr: "node_names: 1 values for 2 host_ports",
+		},
+		{
+			name:    "duplicate host port",
+			nodes:   "node1,node2",
+			ports:   "1,1",
+			wantErr: `duplicate host port "1"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topo, err := FromLegacy("http://pool/resources", "http://pool/allocation", tt.nodes, tt.ips, tt.ports, tt.switches)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("FromLegacy() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromLegacy() error = %v", err)
+			}
+			if got := topo.HostPorts(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HostPorts() = %+v, want %+v", got, tt.want)
+			}
+			if topo.Endpoints.Resources != "http://pool/resources" || topo.Endpoints.Allocation != "http://pool/allocation" {
+				t.Errorf("Endpoints = %+v, want the legacy endpoints", topo.Endpoints)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package topology
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch reloads the topology file whenever it changes and passes every valid version to onChange.
+// An invalid version is passed to onError and ignored, the caller keeps using the last valid topology.
+// Watching stops when stop is closed.
+func Watch(path string, stop <-chan struct{}, onChange func(*Topology), onError func(error)) error {
+	return WatchFile(path, stop, func(buf []byte) {
+		t, err := Parse(buf)
+		if err != nil {
+			onError(fmt.Errorf("%s: %w", path, err))
+			return
+		}
+		onChange(t)
+	}, onError)
+}
+
+// WatchFile passes the content of the file to onChange whenever it changes, including when the file is created.
+// A ConfigMap volume is updated by swapping the ..data symlink of its directory, so the directory is watched rather than the file.
+func WatchFile(path string, stop <-chan struct{}, onChange func([]byte), onError func(error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	// The file as it was loaded at startup, events which do not change it are ignored
+	last, _ := os.ReadFile(path)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				buf, err := os.ReadFile(path)
+				if os.IsNotExist(err) {
+					continue // between the removal of the old symlink and the creation of the new one
+				}
+				if err != nil {
+					onError(err)
+					continue
+				}
+				if bytes.Equal(buf, last) {
+					continue
+				}
+				last = buf
+				onChange(buf)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onError(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Diff returns the nodes whose host port was added, recabled or removed from old to new
+func Diff(old *Topology, new *Topology) (added []string, recabled []string, removed []string) {
+	oldPorts := old.NodePorts()
+	newPorts := new.NodePorts()
+	for node, port := range newPorts {
+		if oldPort, ok := oldPorts[node]; !ok {
+			added = append(added, node)
+		} else if oldPort != port {
+			recabled = append(recabled, node)
+		}
+	}
+	for node := range oldPorts {
+		if _, ok := newPorts[node]; !ok {
+			removed = append(removed, node)
+		}
+	}
+	return added, recabled, removed
+}